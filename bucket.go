@@ -20,25 +20,274 @@ const (
 	BucketMetricSum = iota
 	// BucketMetricSumOfSquares represents the sum of squares of all datapoints seen
 	BucketMetricSumOfSquares = iota
+	// BucketMetricMean represents the mean of all datapoints seen.  It
+	// is disabled by default.
+	BucketMetricMean = iota
+	// BucketMetricStdDev represents the population standard deviation
+	// of all datapoints seen.  It is disabled by default.
+	BucketMetricStdDev = iota
+	// BucketMetricRate represents the count of datapoints seen per
+	// BucketOptions.RateInterval.  It is disabled by default.
+	BucketMetricRate = iota
 )
 
+// bucketMetricNames are the default rollup values, or metric name
+// suffixes, of each bucket metric
+var bucketMetricNames = map[int]string{
+	BucketMetricCount:        "count",
+	BucketMetricMin:          "min",
+	BucketMetricMax:          "max",
+	BucketMetricSum:          "sum",
+	BucketMetricSumOfSquares: "sumofsquares",
+	BucketMetricMean:         "mean",
+	BucketMetricStdDev:       "stddev",
+	BucketMetricRate:         "rate",
+}
+
+// BucketNaming is how the DataPoints of a Bucket's metrics are told
+// apart
+type BucketNaming int
+
+const (
+	// BucketRollupDimension adds a dimension with the name of each
+	// metric, e.g. rollup=count.  It is the default.
+	BucketRollupDimension BucketNaming = iota
+	// BucketMetricSuffix appends the name of each metric to the
+	// Bucket's metric name, e.g. latency.count.
+	BucketMetricSuffix
+)
+
+// DefaultBucketRollupKey is the key of the dimension added by
+// BucketRollupDimension
+const DefaultBucketRollupKey = "rollup"
+
+// apply names dp, of the metric called name, as n says, using rollupKey
+// for BucketRollupDimension.  The dimensions of dp are not modified.
+func (n BucketNaming) apply(dp *DataPoint, rollupKey, name string) {
+	switch n {
+	case BucketMetricSuffix:
+		dp.Metric += "." + name
+	default:
+		dp.Dimensions = sfxproto.Dimensions(map[string]string{rollupKey: name}).Append(dp.Dimensions)
+	}
+}
+
+// BucketOptions configure which metrics a Bucket reports, and how.
+type BucketOptions struct {
+	// Metrics are the metrics reported, such as BucketMetricCount.  If
+	// empty, the count, min, max, sum and sum of squares are reported.
+	Metrics []int
+
+	// Naming is how the DataPoints of each metric are named.
+	Naming BucketNaming
+
+	// RollupKey is the key of the dimension added by
+	// BucketRollupDimension.  If empty, DefaultBucketRollupKey is used.
+	RollupKey string
+
+	// Names override the rollup values, or metric name suffixes, of
+	// the given metrics.  Otherwise they are "count", "min", "max",
+	// "sum", "sumofsquares", "mean", "stddev" and "rate".
+	Names map[int]string
+
+	// RateInterval is the interval per which BucketMetricRate is
+	// reported: the count is divided by the time since the Bucket was
+	// last reported (or created), then multiplied by RateInterval.  If
+	// zero, the rate is per second.
+	RateInterval time.Duration
+}
+
+// bucketOptions returns the first of options, if any, with its
+// defaults filled in, along with the metrics it disables
+func bucketOptions(options []BucketOptions) (BucketOptions, map[int]bool) {
+	var ret BucketOptions
+	if len(options) > 0 {
+		ret = options[0]
+	}
+
+	if ret.RollupKey == "" {
+		ret.RollupKey = DefaultBucketRollupKey
+	}
+	if ret.RateInterval <= 0 {
+		ret.RateInterval = time.Second
+	}
+
+	names := make(map[int]string, len(bucketMetricNames))
+	for metric, name := range bucketMetricNames {
+		names[metric] = name
+		if name, ok := ret.Names[metric]; ok && name != "" {
+			names[metric] = name
+		}
+	}
+	ret.Names = names
+
+	disabled := map[int]bool{
+		BucketMetricMean:   true,
+		BucketMetricStdDev: true,
+		BucketMetricRate:   true,
+	}
+	if len(ret.Metrics) > 0 {
+		disabled = make(map[int]bool, len(bucketMetricNames))
+		for metric := range bucketMetricNames {
+			disabled[metric] = true
+		}
+		for _, metric := range ret.Metrics {
+			delete(disabled, metric)
+		}
+	}
+	ret.Metrics = nil
+
+	return ret, disabled
+}
+
+// bucket is the set of values tracked by a Reporter for Bucket and
+// ShardedBucket alike
+type bucket interface {
+	Metric() string
+	Dimensions() map[string]string
+	Count() uint64
+	Min() int64
+	Max() int64
+	Sum() int64
+	Overflowed() bool
+	DataPoints() []DataPoint
+	snapshot() []DataPoint
+	metricOf(dp DataPoint) (int, bool)
+}
+
+// bucketStats are the statistics of the values added to a bucket since
+// it was last reported.  The sum is kept exactly, saturating and
+// flagging overflow; the sum of squares is accumulated as a float64,
+// and the mean and variance with Welford's algorithm, so that none of
+// them overflow or lose precision as the values grow.
+type bucketStats struct {
+	count        uint64
+	min          int64
+	max          int64
+	sum          int64
+	overflow     bool
+	sumOfSquares float64
+	mean         float64
+	m2           float64 // the sum of squared differences from the mean
+}
+
+func newBucketStats() bucketStats {
+	return bucketStats{min: math.MaxInt64, max: math.MinInt64}
+}
+
+// addInt64 returns a+b, and whether it did not overflow
+func addInt64(a, b int64) (int64, bool) {
+	s := a + b
+	return s, (s >= a) == (b >= 0)
+}
+
+// saturate returns the int64 closest to v
+func saturate(v float64) int64 {
+	switch {
+	case v >= math.MaxInt64:
+		return math.MaxInt64
+	case v <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int64(math.Floor(v + 0.5))
+}
+
+func (s *bucketStats) add(val int64) {
+	s.count++
+	if val < s.min {
+		s.min = val
+	}
+	if val > s.max {
+		s.max = val
+	}
+
+	if !s.overflow {
+		var ok bool
+		if s.sum, ok = addInt64(s.sum, val); !ok {
+			s.overflow = true
+			if val > 0 {
+				s.sum = math.MaxInt64
+			} else {
+				s.sum = math.MinInt64
+			}
+		}
+	}
+
+	v := float64(val)
+	s.sumOfSquares += v * v
+	delta := v - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (v - s.mean)
+}
+
+// merge adds the statistics of o to those of s, combining the means and
+// variances as per Chan et al.
+func (s *bucketStats) merge(o bucketStats) {
+	if o.count == 0 {
+		return
+	}
+	if s.count == 0 {
+		*s = o
+		return
+	}
+
+	if o.min < s.min {
+		s.min = o.min
+	}
+	if o.max > s.max {
+		s.max = o.max
+	}
+
+	switch {
+	case s.overflow:
+	case o.overflow:
+		s.overflow = true
+		s.sum = o.sum
+	default:
+		var ok bool
+		if s.sum, ok = addInt64(s.sum, o.sum); !ok {
+			s.overflow = true
+			if o.sum > 0 {
+				s.sum = math.MaxInt64
+			} else {
+				s.sum = math.MinInt64
+			}
+		}
+	}
+
+	na, nb := float64(s.count), float64(o.count)
+	n := na + nb
+	delta := o.mean - s.mean
+	s.mean += delta * nb / n
+	s.m2 += o.m2 + delta*delta*na*nb/n
+	s.sumOfSquares += o.sumOfSquares
+	s.count += o.count
+}
+
+// stdDev returns the population standard deviation of the values
+func (s *bucketStats) stdDev() float64 {
+	if s.count == 0 || s.m2 <= 0 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.count))
+}
+
 // A Bucket trakcs groups of values, reporting metrics as gauges and
 // resetting each time it reports. All operations on Buckets are goroutine safe.
+//
+// Each value is added under the Bucket's lock, and DataPoints resets all
+// of the statistics at once, so that every value is reported exactly
+// once and all the statistics of a report cover the same values.  A
+// Bucket to which many goroutines add values at once should be a
+// ShardedBucket instead.
 type Bucket struct {
-	metric             string
-	dimensions         map[string]string
-	count              uint64
-	countMetric        Counter
-	min                int64
-	minMetric          Gauge
-	max                int64
-	maxMetric          Gauge
-	sum                int64
-	sumMetric          Gauge
-	sumOfSquares       int64
-	sumOfSquaresMetric Gauge
-	mu                 sync.Mutex
-	disabledMetrics    map[int]bool
+	metric     string
+	dimensions map[string]string
+	bucketStats
+	mu              sync.Mutex
+	disabledMetrics map[int]bool
+	options         BucketOptions
+	lastReset       time.Time
 }
 
 func (b *Bucket) lock() {
@@ -58,12 +307,10 @@ func (b *Bucket) Clone() *Bucket {
 	return &Bucket{
 		metric:          b.metric,                                  // can't use Metric() since we already have a lock
 		dimensions:      sfxproto.Dimensions(b.dimensions).Clone(), // can't use Dimensions() since we already have a lock
-		count:           b.Count(),
-		min:             b.Min(),
-		max:             b.Max(),
-		sum:             b.Sum(),
-		sumOfSquares:    b.SumOfSquares(),
+		bucketStats:     b.bucketStats,
 		disabledMetrics: b.disabledMetrics,
+		options:         b.options,
+		lastReset:       b.lastReset,
 	}
 }
 
@@ -77,6 +324,16 @@ func (b *Bucket) Disable(metrics ...int) {
 	}
 }
 
+// Enable enables the given metrics for this bucket, such as those
+// which are disabled by default.
+func (b *Bucket) Enable(metrics ...int) {
+	b.lock()
+	defer b.unlock()
+	for _, metric := range metrics {
+		delete(b.disabledMetrics, metric)
+	}
+}
+
 // Equal returns whether two buckets are exactly equal
 func (b *Bucket) Equal(r *Bucket) bool {
 	// lock the state of both buckets as this operation is effectively a
@@ -96,27 +353,8 @@ func (b *Bucket) Equal(r *Bucket) bool {
 		return false
 	}
 
-	if b.Count() != r.Count() {
-		return false
-	}
-
-	if b.Min() != r.Min() {
-		return false
-	}
-
-	if b.Max() != r.Max() {
-		return false
-	}
-
-	if b.Sum() != r.Sum() {
-		return false
-	}
-
-	if b.SumOfSquares() != r.SumOfSquares() {
-		return false
-	}
-
-	return true
+	// can't use the getters since we already have the locks
+	return b.bucketStats == r.bucketStats
 }
 
 // Metric returns the metric name of the Bucket
@@ -178,59 +416,105 @@ func (b *Bucket) RemoveDimension(keys ...string) {
 
 // Count returns the number of items added to the Bucket
 func (b *Bucket) Count() uint64 {
-	return atomic.LoadUint64(&b.count)
+	b.lock()
+	defer b.unlock()
+
+	return b.count
 }
 
 // Min returns the lowest item added to the Bucket
 func (b *Bucket) Min() int64 {
-	return atomic.LoadInt64(&b.min)
+	b.lock()
+	defer b.unlock()
+
+	return b.min
 }
 
 // Max returns the highest item added to the Bucket
 func (b *Bucket) Max() int64 {
-	return atomic.LoadInt64(&b.max)
+	b.lock()
+	defer b.unlock()
+
+	return b.max
 }
 
-// Sum returns the sum of all items added to the Bucket
+// Sum returns the sum of all items added to the Bucket.  If it has
+// overflowed, it is saturated at math.MaxInt64 or math.MinInt64.
 func (b *Bucket) Sum() int64 {
-	return atomic.LoadInt64(&b.sum)
+	b.lock()
+	defer b.unlock()
+
+	return b.sum
 }
 
-// SumOfSquares returns the sum of the square of all items added to the Bucket
+// Overflowed returns whether the sum of the items added to the Bucket
+// has overflowed an int64
+func (b *Bucket) Overflowed() bool {
+	b.lock()
+	defer b.unlock()
+
+	return b.overflow
+}
+
+// SumOfSquares returns the sum of the square of all items added to the
+// Bucket, rounded and saturated to an int64
 func (b *Bucket) SumOfSquares() int64 {
-	return atomic.LoadInt64(&b.sumOfSquares)
+	b.lock()
+	defer b.unlock()
+
+	return saturate(b.sumOfSquares)
+}
+
+// Mean returns the mean of all items added to the Bucket, or 0 if there
+// are none
+func (b *Bucket) Mean() float64 {
+	b.lock()
+	defer b.unlock()
+
+	return b.mean
+}
+
+// StdDev returns the population standard deviation of all items added
+// to the Bucket, or 0 if there are none
+func (b *Bucket) StdDev() float64 {
+	b.lock()
+	defer b.unlock()
+
+	return b.stdDev()
 }
 
 // NewBucket creates a new Bucket. Because the passed in dimensions can not be
 // locked by this method, it is important that the caller ensures its state does
-// not change for the duration of the operation.
-func NewBucket(metric string, dimensions map[string]string) *Bucket {
+// not change for the duration of the operation.  It is configured by the first
+// of options, if any; by default, the mean, standard deviation and rate are not
+// reported unless enabled with Enable.
+func NewBucket(metric string, dimensions map[string]string, options ...BucketOptions) *Bucket {
+	opts, disabled := bucketOptions(options)
 	return &Bucket{
 		metric:          metric,
 		dimensions:      sfxproto.Dimensions(dimensions).Clone(),
-		min:             math.MaxInt64,
-		max:             math.MinInt64,
-		disabledMetrics: make(map[int]bool, 0),
+		bucketStats:     newBucketStats(),
+		disabledMetrics: disabled,
+		options:         opts,
+		lastReset:       time.Now(),
 	}
 }
 
 // Add an item to the Bucket, later reporting the result in the next report
 // cycle.
 func (b *Bucket) Add(val int64) {
-	// still use atomic though, so that the atomic "getters" will never read
-	// from an inconsistent state
-	_ = atomic.AddUint64(&b.count, 1)
-	atomic.AddInt64(&b.sum, val)
-	atomic.AddInt64(&b.sumOfSquares, val*val)
+	b.lock()
+	defer b.unlock()
 
-	b.setIfMin(val)
-	b.setIfMax(val)
+	b.add(val)
 }
 
-func (b *Bucket) setIfMin(val int64) {
+// setIfMin atomically stores val at addr if it is lower than the value
+// there, or if that value is unset (i.e. math.MaxInt64)
+func setIfMin(addr *int64, val int64) {
 	for {
-		if cur := b.Min(); cur > val || cur == math.MaxInt64 {
-			if atomic.CompareAndSwapInt64(&b.min, cur, val) {
+		if cur := atomic.LoadInt64(addr); cur > val || cur == math.MaxInt64 {
+			if atomic.CompareAndSwapInt64(addr, cur, val) {
 				break
 			}
 		} else {
@@ -239,10 +523,12 @@ func (b *Bucket) setIfMin(val int64) {
 	}
 }
 
-func (b *Bucket) setIfMax(val int64) {
+// setIfMax atomically stores val at addr if it is higher than the value
+// there, or if that value is unset (i.e. math.MinInt64)
+func setIfMax(addr *int64, val int64) {
 	for {
-		if cur := b.Max(); cur < val || cur == math.MinInt64 {
-			if atomic.CompareAndSwapInt64(&b.max, cur, val) {
+		if cur := atomic.LoadInt64(addr); cur < val || cur == math.MinInt64 {
+			if atomic.CompareAndSwapInt64(addr, cur, val) {
 				break
 			}
 		} else {
@@ -251,79 +537,117 @@ func (b *Bucket) setIfMax(val int64) {
 	}
 }
 
-func (b *Bucket) dimFor(rollup string) map[string]string {
-	b.lock()
-	defer b.unlock()
-
-	return sfxproto.Dimensions(map[string]string{"rollup": rollup}).Append(b.dimensions)
+// elapsed returns the time since the Bucket was last reset, resetting
+// it now if reset is true.  b.mu must be held, so that the Bucket's
+// values are reset along with it.
+func (b *Bucket) elapsed(reset bool) time.Duration {
+	now := time.Now()
+	ret := now.Sub(b.lastReset)
+	if reset {
+		b.lastReset = now
+	}
+	return ret
 }
 
 // DataPoints returns a DataPoints object with DataPoint values for
-// Count, Sum, SumOfSquares, Min and Max (if set). Note that this
-// resets all values.  If no values have been added to the bucket
-// since the last report, it returns 0 for count, sum and
-// sum-of-squares, omitting max and min.  If the count is higher than
-// may be represented in an int64, then the count will be omitted.
+// Count, Sum, SumOfSquares, Min and Max (if set), as well as Mean,
+// StdDev and Rate if enabled. Note that this resets all values.  If no
+// values have been added to the bucket since the last report, it
+// returns 0 for count, sum, sum-of-squares and rate, omitting max, min,
+// mean and standard deviation.  If the count, sum or sum-of-squares is
+// higher than may be represented in an int64, then it will be omitted.
+// The mean, standard deviation and rate are rounded to the nearest
+// integer.
 func (b *Bucket) DataPoints() []DataPoint {
-	dps := make([]DataPoint, 0, 5)
-	cnt := atomic.SwapUint64(&b.count, 0)
-	min := atomic.SwapInt64(&b.min, math.MaxInt64)
-	max := atomic.SwapInt64(&b.max, math.MinInt64)
-	sum := atomic.SwapInt64(&b.sum, 0)
-	sos := atomic.SwapInt64(&b.sumOfSquares, 0)
-	timestamp := time.Now()
-	if cnt != 0 {
-		if !b.disabledMetrics[BucketMetricMin] {
-			dp := DataPoint{
-				Metric:     b.metric,
-				Dimensions: b.dimFor("min"),
-				Type:       GaugeType,
-				Value:      min,
-				Timestamp:  timestamp,
+	b.lock()
+	stats := b.bucketStats
+	b.bucketStats = newBucketStats()
+	elapsed := b.elapsed(true)
+	b.unlock()
+
+	return b.dataPoints(stats, elapsed)
+}
+
+// snapshot returns the same DataPoints as DataPoints would, without
+// resetting any values.
+func (b *Bucket) snapshot() []DataPoint {
+	b.lock()
+	stats := b.bucketStats
+	elapsed := b.elapsed(false)
+	b.unlock()
+
+	return b.dataPoints(stats, elapsed)
+}
+
+// metricOf returns which of the Bucket's metrics dp is, if any
+func (b *Bucket) metricOf(dp DataPoint) (int, bool) {
+	b.lock()
+	defer b.unlock()
+
+	for metric, name := range b.options.Names {
+		switch b.options.Naming {
+		case BucketMetricSuffix:
+			if dp.Metric == b.metric+"."+name {
+				return metric, true
 			}
-			dps = append(dps, dp)
-		}
-		if !b.disabledMetrics[BucketMetricMax] {
-			dp := DataPoint{
-				Metric:     b.metric,
-				Dimensions: b.dimFor("max"),
-				Type:       GaugeType,
-				Value:      max,
-				Timestamp:  timestamp,
+		default:
+			if dp.Metric == b.metric && dp.Dimensions[b.options.RollupKey] == name {
+				return metric, true
 			}
-			dps = append(dps, dp)
 		}
 	}
-	if !b.disabledMetrics[BucketMetricCount] && cnt <= math.MaxInt64 {
-		dp := DataPoint{
-			Metric:     b.metric,
-			Dimensions: b.dimFor("count"),
-			Type:       CounterType,
-			Value:      int64(cnt),
-			Timestamp:  timestamp,
-		}
-		dps = append(dps, dp)
+	return 0, false
+}
+
+// dataPoints renders stats, gathered over elapsed, as DataPoints
+func (b *Bucket) dataPoints(stats bucketStats, elapsed time.Duration) []DataPoint {
+	b.lock()
+	metric := b.metric
+	dimensions := sfxproto.Dimensions(b.dimensions).Clone()
+	disabled := make(map[int]bool, len(b.disabledMetrics))
+	for k, v := range b.disabledMetrics {
+		disabled[k] = v
 	}
-	if !b.disabledMetrics[BucketMetricSum] {
-		dp := DataPoint{
-			Metric:     b.metric,
-			Dimensions: b.dimFor("sum"),
-			Type:       GaugeType,
-			Value:      sum,
-			Timestamp:  timestamp,
+	b.unlock()
+
+	dps := make([]DataPoint, 0, len(bucketMetricNames))
+	timestamp := time.Now()
+	add := func(which int, typ MetricType, value int64) {
+		if disabled[which] {
+			return
 		}
-		dps = append(dps, dp)
-	}
-	if !b.disabledMetrics[BucketMetricSumOfSquares] {
 		dp := DataPoint{
-			Metric:     b.metric,
-			Dimensions: b.dimFor("sumofsquares"),
-			Type:       GaugeType,
-			Value:      sos,
+			Metric:     metric,
+			Dimensions: dimensions,
+			Type:       typ,
+			Value:      value,
 			Timestamp:  timestamp,
 		}
+		b.options.Naming.apply(&dp, b.options.RollupKey, b.options.Names[which])
 		dps = append(dps, dp)
 	}
 
+	if stats.count != 0 {
+		add(BucketMetricMin, GaugeType, stats.min)
+		add(BucketMetricMax, GaugeType, stats.max)
+	}
+	if stats.count <= math.MaxInt64 {
+		add(BucketMetricCount, CounterType, int64(stats.count))
+	}
+	if !stats.overflow {
+		add(BucketMetricSum, GaugeType, stats.sum)
+	}
+	if stats.sumOfSquares < math.MaxInt64 {
+		add(BucketMetricSumOfSquares, GaugeType, saturate(stats.sumOfSquares))
+	}
+	if stats.count != 0 {
+		add(BucketMetricMean, GaugeType, saturate(stats.mean))
+		add(BucketMetricStdDev, GaugeType, saturate(stats.stdDev()))
+	}
+	if elapsed > 0 {
+		rate := float64(stats.count) / elapsed.Seconds() * b.options.RateInterval.Seconds()
+		add(BucketMetricRate, GaugeType, saturate(rate))
+	}
+
 	return dps
 }
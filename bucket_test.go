@@ -157,6 +157,149 @@ func TestBucket(t *testing.T) {
 			So(b.Count(), ShouldEqual, 3)
 			So(len(b.DataPoints()), ShouldEqual, 4)
 		})
+
+		Convey("mean and standard deviation should be reported when enabled", func() {
+			for _, v := range []int64{2, 4, 4, 4, 5, 5, 7, 9} {
+				b.Add(v)
+			}
+			So(b.Mean(), ShouldEqual, 5)
+			So(b.StdDev(), ShouldEqual, 2)
+			So(len(b.snapshot()), ShouldEqual, 5)
+
+			b.Enable(BucketMetricMean, BucketMetricStdDev)
+			dps := b.DataPoints()
+			So(len(dps), ShouldEqual, 7)
+			So(dps[5].Dimensions["rollup"], ShouldEqual, "mean")
+			So(dps[5].Value, ShouldEqual, 5)
+			So(dps[6].Dimensions["rollup"], ShouldEqual, "stddev")
+			So(dps[6].Value, ShouldEqual, 2)
+
+			So(b.Mean(), ShouldEqual, 0)
+			So(b.StdDev(), ShouldEqual, 0)
+			So(len(b.DataPoints()), ShouldEqual, 3)
+		})
+
+		Convey("large values should not overflow", func() {
+			b.Add(4e9)
+			b.Add(5e9)
+			So(b.SumOfSquares(), ShouldEqual, int64(math.MaxInt64))
+			So(b.Mean(), ShouldEqual, 4.5e9)
+			So(b.StdDev(), ShouldEqual, 0.5e9)
+
+			b.Add(math.MaxInt64)
+			So(b.Overflowed(), ShouldBeTrue)
+			So(b.Sum(), ShouldEqual, int64(math.MaxInt64))
+			So(b.SumOfSquares(), ShouldEqual, int64(math.MaxInt64))
+			b.Add(math.MinInt64)
+			So(b.Sum(), ShouldEqual, int64(math.MaxInt64))
+			So(b.Max(), ShouldEqual, int64(math.MaxInt64))
+			So(b.Min(), ShouldEqual, int64(math.MinInt64))
+
+			values := map[string]int64{}
+			for _, dp := range b.DataPoints() {
+				values[dp.Dimensions["rollup"]] = dp.Value
+			}
+			So(values, ShouldResemble, map[string]int64{
+				"min":   math.MinInt64,
+				"max":   math.MaxInt64,
+				"count": 4,
+			})
+			So(b.Overflowed(), ShouldBeFalse)
+
+			b.Add(math.MinInt64)
+			b.Add(-1)
+			So(b.Overflowed(), ShouldBeTrue)
+			So(b.Sum(), ShouldEqual, int64(math.MinInt64))
+		})
+
+		Convey("options should configure what is reported", func() {
+			b := NewBucket("latency", map[string]string{"c": "3"}, BucketOptions{
+				Metrics: []int{BucketMetricCount, BucketMetricMax, BucketMetricMean},
+				Naming:  BucketMetricSuffix,
+				Names:   map[int]string{BucketMetricMax: "maximum"},
+			})
+			b.Add(1)
+			b.Add(3)
+
+			dps := b.DataPoints()
+			So(len(dps), ShouldEqual, 3)
+			for _, dp := range dps {
+				So(dp.Dimensions, ShouldResemble, map[string]string{"c": "3"})
+			}
+			So(dps[0].Metric, ShouldEqual, "latency.maximum")
+			So(dps[0].Value, ShouldEqual, 3)
+			So(dps[1].Metric, ShouldEqual, "latency.count")
+			So(dps[1].Value, ShouldEqual, 2)
+			So(dps[2].Metric, ShouldEqual, "latency.mean")
+			So(dps[2].Value, ShouldEqual, 2)
+
+			metric, ok := b.metricOf(dps[0])
+			So(ok, ShouldBeTrue)
+			So(metric, ShouldEqual, BucketMetricMax)
+			_, ok = b.metricOf(DataPoint{Metric: "latency"})
+			So(ok, ShouldBeFalse)
+
+			b = NewBucket("latency", nil, BucketOptions{RollupKey: "stat"})
+			b.Add(1)
+			dps = b.DataPoints()
+			So(len(dps), ShouldEqual, 5)
+			So(dps[2].Dimensions, ShouldResemble, map[string]string{"stat": "count"})
+			metric, ok = b.metricOf(dps[2])
+			So(ok, ShouldBeTrue)
+			So(metric, ShouldEqual, BucketMetricCount)
+		})
+
+		Convey("rates should be normalized by interval", func() {
+			b := NewBucket("requests", nil, BucketOptions{Metrics: []int{BucketMetricRate}})
+			for i := 0; i < 10; i++ {
+				b.Add(1)
+			}
+			b.lastReset = time.Now().Add(-2 * time.Second)
+			So(b.snapshot()[0].Value, ShouldEqual, 5)
+
+			dps := b.DataPoints()
+			So(len(dps), ShouldEqual, 1)
+			So(dps[0].Dimensions["rollup"], ShouldEqual, "rate")
+			So(dps[0].Value, ShouldEqual, 5)
+			So(b.DataPoints()[0].Value, ShouldEqual, 0)
+
+			b = NewBucket("requests", nil, BucketOptions{
+				Metrics:      []int{BucketMetricRate},
+				RateInterval: time.Minute,
+			})
+			for i := 0; i < 10; i++ {
+				b.Add(1)
+			}
+			b.lastReset = time.Now().Add(-2 * time.Second)
+			So(b.DataPoints()[0].Value, ShouldEqual, 300)
+		})
+
+		Convey("each report should cover the same values", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 100000; i++ {
+					b.Add(1)
+				}
+			}()
+
+			var total int64
+			for finished := false; !finished; {
+				select {
+				case <-done:
+					finished = true
+				default:
+				}
+				values := map[string]int64{}
+				for _, dp := range b.DataPoints() {
+					values[dp.Dimensions["rollup"]] = dp.Value
+				}
+				So(values["sum"], ShouldEqual, values["count"])
+				So(values["sumofsquares"], ShouldEqual, values["count"])
+				total += values["count"]
+			}
+			So(total, ShouldEqual, 100000)
+		})
 	})
 }
 
@@ -0,0 +1,312 @@
+package signalfx
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCgroupRoot is where the cgroup filesystem is normally
+// mounted.  Within a container this is the container's own cgroup.
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// cgroupUnlimited is the threshold above which a cgroup v1 memory
+// limit is considered to be unset (the kernel reports a page-aligned
+// LONG_MAX)
+const cgroupUnlimited = 1 << 62
+
+var errCgroupUnlimited = errors.New("unlimited")
+
+// cgroupMetricTypes are the metrics CgroupMetrics may report, and
+// their types.  Which of them are reported depends on the cgroup
+// version and the controllers available.
+var cgroupMetricTypes = []struct {
+	metric string
+	t      MetricType
+}{
+	{"cgroup-cpu-usage-ns", CumulativeCounterType},
+	{"cgroup-cpu-user-ns", CumulativeCounterType},
+	{"cgroup-cpu-system-ns", CumulativeCounterType},
+	{"cgroup-cpu-periods", CumulativeCounterType},
+	{"cgroup-cpu-throttled-periods", CumulativeCounterType},
+	{"cgroup-cpu-throttled-ns", CumulativeCounterType},
+	{"cgroup-cpu-quota-millicores", GaugeType},
+	{"cgroup-memory-usage-bytes", GaugeType},
+	{"cgroup-memory-limit-bytes", GaugeType},
+	{"cgroup-memory-oom-events", CumulativeCounterType},
+	{"cgroup-memory-oom-kills", CumulativeCounterType},
+	{"cgroup-cpu-pressure-some-us", CumulativeCounterType},
+	{"cgroup-cpu-pressure-full-us", CumulativeCounterType},
+	{"cgroup-memory-pressure-some-us", CumulativeCounterType},
+	{"cgroup-memory-pressure-full-us", CumulativeCounterType},
+	{"cgroup-io-pressure-some-us", CumulativeCounterType},
+	{"cgroup-io-pressure-full-us", CumulativeCounterType},
+}
+
+// CgroupMetrics gathers and reports container resource stats, read
+// from the cgroup v1 or v2 filesystem, for the reporter
+type CgroupMetrics struct {
+	metrics  []Metric
+	reporter *Reporter
+	version  int
+}
+
+// NewCgroupMetrics registers the reporter to report the cgroup stats
+// found at DefaultCgroupRoot.  You should provide enough dims to
+// differentiate this set of metrics.  Values which are unavailable
+// (e.g. pressure stats under cgroup v1, or an unset limit) are simply
+// never reported.
+func NewCgroupMetrics(reporter *Reporter, dims map[string]string) *CgroupMetrics {
+	return newCgroupMetrics(reporter, dims, DefaultCgroupRoot)
+}
+
+func newCgroupMetrics(reporter *Reporter, dims map[string]string, root string) *CgroupMetrics {
+	var mu sync.Mutex
+	values := map[string]int64{}
+	ret := &CgroupMetrics{
+		reporter: reporter,
+		version:  cgroupVersion(root),
+	}
+
+	for _, mt := range cgroupMetricTypes {
+		metric := mt.metric
+		getter := GetterFunc(func() (interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			v, ok := values[metric]
+			if !ok {
+				return nil, ErrNotAvailable
+			}
+			return v, nil
+		})
+
+		switch mt.t {
+		case GaugeType:
+			ret.metrics = append(ret.metrics, WrapGauge(metric, dims, getter))
+		case CumulativeCounterType:
+			ret.metrics = append(ret.metrics, WrapCumulativeCounter(metric, dims, getter))
+		}
+	}
+	reporter.Track(ret.metrics...)
+
+	reporter.AddPreReportCallback(func() {
+		var v map[string]int64
+		switch ret.version {
+		case 1:
+			v = readCgroupV1(root)
+		case 2:
+			v = readCgroupV2(root)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		values = v
+	})
+
+	return ret
+}
+
+// Version returns the detected cgroup version, 1 or 2, or 0 if no
+// cgroup filesystem was found.
+func (c *CgroupMetrics) Version() int {
+	return c.version
+}
+
+// Close the metric source and will stop reporting these cgroup stats
+// to the reporter. Implements the io.Closer interface.
+func (c *CgroupMetrics) Close() error {
+	c.reporter.Untrack(c.metrics...)
+	return nil
+}
+
+// cgroupVersion detects the version of the cgroup filesystem at root
+func cgroupVersion(root string) int {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return 2
+	}
+	for _, controller := range []string{"cpu", "cpuacct", "memory"} {
+		if _, err := os.Stat(filepath.Join(root, controller)); err == nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+// readCgroupInt reads a file containing a single integer.  A value of
+// "max" (or -1) denotes an unset limit.
+func readCgroupInt(name string) (int64, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	str := strings.TrimSpace(string(data))
+	if str == "max" || str == "-1" {
+		return 0, errCgroupUnlimited
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+// readCgroupFlat reads a flat keyed file, i.e. one "key value" pair per
+// line
+func readCgroupFlat(name string) (map[string]int64, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]int64{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			ret[fields[0]] = v
+		}
+	}
+	return ret, nil
+}
+
+// readCgroupPressure reads the total stall times out of a PSI file
+// such as cpu.pressure, e.g.:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=1234
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=567
+func readCgroupPressure(name string, resource string, values map[string]int64) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "total=") {
+				continue
+			}
+			if v, err := strconv.ParseInt(field[len("total="):], 10, 64); err == nil {
+				values["cgroup-"+resource+"-pressure-"+fields[0]+"-us"] = v
+			}
+		}
+	}
+}
+
+func readCgroupV2(root string) map[string]int64 {
+	values := map[string]int64{}
+	us := int64(time.Microsecond)
+
+	if stat, err := readCgroupFlat(filepath.Join(root, "cpu.stat")); err == nil {
+		for key, metric := range map[string]string{
+			"usage_usec":     "cgroup-cpu-usage-ns",
+			"user_usec":      "cgroup-cpu-user-ns",
+			"system_usec":    "cgroup-cpu-system-ns",
+			"throttled_usec": "cgroup-cpu-throttled-ns",
+		} {
+			if v, ok := stat[key]; ok {
+				values[metric] = v * us
+			}
+		}
+		for key, metric := range map[string]string{
+			"nr_periods":   "cgroup-cpu-periods",
+			"nr_throttled": "cgroup-cpu-throttled-periods",
+		} {
+			if v, ok := stat[key]; ok {
+				values[metric] = v
+			}
+		}
+	}
+
+	// cpu.max is "$QUOTA $PERIOD", where $QUOTA may be "max"
+	if data, err := ioutil.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 {
+			quota, qerr := strconv.ParseInt(fields[0], 10, 64)
+			period, perr := strconv.ParseInt(fields[1], 10, 64)
+			if qerr == nil && perr == nil && period > 0 {
+				values["cgroup-cpu-quota-millicores"] = quota * 1000 / period
+			}
+		}
+	}
+
+	if v, err := readCgroupInt(filepath.Join(root, "memory.current")); err == nil {
+		values["cgroup-memory-usage-bytes"] = v
+	}
+	if v, err := readCgroupInt(filepath.Join(root, "memory.max")); err == nil {
+		values["cgroup-memory-limit-bytes"] = v
+	}
+	if events, err := readCgroupFlat(filepath.Join(root, "memory.events")); err == nil {
+		if v, ok := events["oom"]; ok {
+			values["cgroup-memory-oom-events"] = v
+		}
+		if v, ok := events["oom_kill"]; ok {
+			values["cgroup-memory-oom-kills"] = v
+		}
+	}
+
+	for _, resource := range []string{"cpu", "memory", "io"} {
+		readCgroupPressure(filepath.Join(root, resource+".pressure"), resource, values)
+	}
+
+	return values
+}
+
+func readCgroupV1(root string) map[string]int64 {
+	values := map[string]int64{}
+
+	cpu := filepath.Join(root, "cpu")
+	cpuacct := filepath.Join(root, "cpuacct")
+	memory := filepath.Join(root, "memory")
+
+	if v, err := readCgroupInt(filepath.Join(cpuacct, "cpuacct.usage")); err == nil {
+		values["cgroup-cpu-usage-ns"] = v
+	}
+	// cpuacct.stat is reported in USER_HZ
+	if stat, err := readCgroupFlat(filepath.Join(cpuacct, "cpuacct.stat")); err == nil {
+		tick := int64(time.Second / userHZ)
+		if v, ok := stat["user"]; ok {
+			values["cgroup-cpu-user-ns"] = v * tick
+		}
+		if v, ok := stat["system"]; ok {
+			values["cgroup-cpu-system-ns"] = v * tick
+		}
+	}
+
+	if stat, err := readCgroupFlat(filepath.Join(cpu, "cpu.stat")); err == nil {
+		for key, metric := range map[string]string{
+			"nr_periods":     "cgroup-cpu-periods",
+			"nr_throttled":   "cgroup-cpu-throttled-periods",
+			"throttled_time": "cgroup-cpu-throttled-ns",
+		} {
+			if v, ok := stat[key]; ok {
+				values[metric] = v
+			}
+		}
+	}
+
+	quota, qerr := readCgroupInt(filepath.Join(cpu, "cpu.cfs_quota_us"))
+	period, perr := readCgroupInt(filepath.Join(cpu, "cpu.cfs_period_us"))
+	if qerr == nil && perr == nil && period > 0 {
+		values["cgroup-cpu-quota-millicores"] = quota * 1000 / period
+	}
+
+	if v, err := readCgroupInt(filepath.Join(memory, "memory.usage_in_bytes")); err == nil {
+		values["cgroup-memory-usage-bytes"] = v
+	}
+	if v, err := readCgroupInt(filepath.Join(memory, "memory.limit_in_bytes")); err == nil && v < cgroupUnlimited {
+		values["cgroup-memory-limit-bytes"] = v
+	}
+	if oom, err := readCgroupFlat(filepath.Join(memory, "memory.oom_control")); err == nil {
+		if v, ok := oom["oom_kill"]; ok {
+			values["cgroup-memory-oom-kills"] = v
+		}
+	}
+
+	return values
+}
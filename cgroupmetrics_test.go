@@ -0,0 +1,103 @@
+package signalfx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestCgroupMetrics(t *testing.T) {
+	Convey("Testing CgroupMetrics", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`"OK"`))
+		}))
+		defer ts.Close()
+
+		config := NewConfig()
+		config.URL = ts.URL
+
+		reporter := NewReporter(config, nil)
+		So(reporter, ShouldNotBeNil)
+
+		report := func() map[string]DataPoint {
+			datapoints, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			ret := map[string]DataPoint{}
+			for _, dp := range datapoints {
+				So(dp.Dimensions, ShouldResemble, map[string]string{"system": "test"})
+				ret[dp.Metric] = dp
+			}
+			return ret
+		}
+
+		Convey("cgroup v2 should be parsed", func() {
+			cm := newCgroupMetrics(reporter, map[string]string{"system": "test"}, "testdata/cgroup/v2")
+			So(cm.Version(), ShouldEqual, 2)
+
+			dps := report()
+			So(len(dps), ShouldEqual, 17)
+
+			So(dps["cgroup-cpu-usage-ns"].Type, ShouldEqual, CumulativeCounterType)
+			So(dps["cgroup-cpu-usage-ns"].Value, ShouldEqual, 5000000000)
+			So(dps["cgroup-cpu-user-ns"].Value, ShouldEqual, 3000000000)
+			So(dps["cgroup-cpu-system-ns"].Value, ShouldEqual, 2000000000)
+			So(dps["cgroup-cpu-periods"].Value, ShouldEqual, 100)
+			So(dps["cgroup-cpu-throttled-periods"].Value, ShouldEqual, 10)
+			So(dps["cgroup-cpu-throttled-ns"].Value, ShouldEqual, 250000000)
+			So(dps["cgroup-cpu-quota-millicores"].Type, ShouldEqual, GaugeType)
+			So(dps["cgroup-cpu-quota-millicores"].Value, ShouldEqual, 500)
+			So(dps["cgroup-memory-usage-bytes"].Type, ShouldEqual, GaugeType)
+			So(dps["cgroup-memory-usage-bytes"].Value, ShouldEqual, 104857600)
+			So(dps["cgroup-memory-limit-bytes"].Value, ShouldEqual, 268435456)
+			So(dps["cgroup-memory-oom-events"].Value, ShouldEqual, 2)
+			So(dps["cgroup-memory-oom-kills"].Value, ShouldEqual, 1)
+			So(dps["cgroup-cpu-pressure-some-us"].Value, ShouldEqual, 123456)
+			So(dps["cgroup-cpu-pressure-full-us"].Value, ShouldEqual, 7890)
+			So(dps["cgroup-memory-pressure-some-us"].Value, ShouldEqual, 42)
+			So(dps["cgroup-memory-pressure-full-us"].Value, ShouldEqual, 21)
+			So(dps["cgroup-io-pressure-some-us"].Value, ShouldEqual, 1000)
+			So(dps["cgroup-io-pressure-full-us"].Value, ShouldEqual, 500)
+
+			// unchanged cumulative counters are not re-reported
+			dps = report()
+			So(len(dps), ShouldEqual, 3)
+
+			So(cm.Close(), ShouldBeNil)
+			So(report(), ShouldBeEmpty)
+		})
+
+		Convey("cgroup v1 should be parsed", func() {
+			cm := newCgroupMetrics(reporter, map[string]string{"system": "test"}, "testdata/cgroup/v1")
+			So(cm.Version(), ShouldEqual, 1)
+
+			dps := report()
+			// no pressure stats or oom events, and an unlimited
+			// memory limit
+			So(len(dps), ShouldEqual, 9)
+
+			So(dps["cgroup-cpu-usage-ns"].Value, ShouldEqual, 6000000000)
+			So(dps["cgroup-cpu-user-ns"].Value, ShouldEqual, 4000000000)
+			So(dps["cgroup-cpu-system-ns"].Value, ShouldEqual, 2000000000)
+			So(dps["cgroup-cpu-periods"].Value, ShouldEqual, 200)
+			So(dps["cgroup-cpu-throttled-periods"].Value, ShouldEqual, 20)
+			So(dps["cgroup-cpu-throttled-ns"].Value, ShouldEqual, 500000000)
+			So(dps["cgroup-cpu-quota-millicores"].Value, ShouldEqual, 1500)
+			So(dps["cgroup-memory-usage-bytes"].Value, ShouldEqual, 52428800)
+			So(dps["cgroup-memory-oom-kills"].Value, ShouldEqual, 3)
+			_, ok := dps["cgroup-memory-limit-bytes"]
+			So(ok, ShouldBeFalse)
+
+			So(cm.Close(), ShouldBeNil)
+		})
+
+		Convey("a missing cgroup filesystem should report nothing", func() {
+			cm := newCgroupMetrics(reporter, map[string]string{"system": "test"}, "testdata/cgroup/missing")
+			So(cm.Version(), ShouldEqual, 0)
+			So(report(), ShouldBeEmpty)
+			So(cm.Close(), ShouldBeNil)
+		})
+	})
+}
@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 	"zvelo.io/go-signalfx/sfxproto"
@@ -14,25 +18,70 @@ import (
 const (
 	// TokenHeader is the header on which SignalFx looks for the api token
 	TokenHeader = "X-SF-TOKEN"
+
+	// maxDebugWrites is the number of payloads a Client writes to its
+	// DebugDir at once; any more are dropped
+	maxDebugWrites = 16
 )
 
+// canceler is implemented by transports which may cancel an in-flight
+// request, such as http.Transport.
+type canceler interface {
+	CancelRequest(*http.Request)
+}
+
 // A Client is used to send datapoints to SignalFx
 type Client struct {
 	config *Config
 	tr     http.RoundTripper
 	client *http.Client
+
+	debugWrites chan struct{}  // a semaphore of maxDebugWrites
+	debugWG     sync.WaitGroup // for tests to wait for writes
 }
 
 // NewClient returns a new Client. config is copied, so future changes to the
 // external config object are not reflected within the client.
 func NewClient(config *Config) *Client {
-	tr := config.Transport()
+	var tr http.RoundTripper = config.RoundTripper
+	if tr == nil {
+		tr = config.Transport()
+	}
 
 	return &Client{
-		config: config.Clone(),
-		tr:     tr,
-		client: &http.Client{Transport: tr},
+		config:      config.Clone(),
+		tr:          tr,
+		client:      &http.Client{Transport: tr},
+		debugWrites: make(chan struct{}, maxDebugWrites),
+	}
+}
+
+// writeDebug writes a copy of a payload to the configured DebugDir in
+// the background, so that a slow disk does not hold up the Reporter.
+// If maxDebugWrites are already in progress, the payload is dropped.
+func (c *Client) writeDebug(data []byte) {
+	select {
+	case c.debugWrites <- struct{}{}:
+	default:
+		if c.config.Logger != nil {
+			fmt.Fprintf(c.config.Logger, "dropped debug payload: %d writes in progress", maxDebugWrites)
+		}
+		return
 	}
+
+	name := filepath.Join(c.config.DebugDir,
+		fmt.Sprintf("datapoints-%s.pb", time.Now().UTC().Format("20060102T150405.000000000")))
+	data = append([]byte(nil), data...)
+
+	c.debugWG.Add(1)
+	go func() {
+		defer c.debugWG.Done()
+		defer func() { <-c.debugWrites }()
+
+		if err := ioutil.WriteFile(name, data, 0644); err != nil && c.config.Logger != nil {
+			fmt.Fprintf(c.config.Logger, "failed to write debug payload: %v", err)
+		}
+	}()
 }
 
 // Submit forwards raw datapoints to SignalFx
@@ -43,12 +92,53 @@ func (c *Client) Submit(ctx context.Context, pdps *sfxproto.DataPoints) error {
 		return ErrContext(ctx.Err())
 	}
 
+	if pdps != nil && c.config.CoalesceDataPoints {
+		pdps = pdps.Coalesce()
+	}
+	if pdps != nil && c.config.SortDataPoints {
+		if !c.config.CoalesceDataPoints {
+			// don't reorder the caller's datapoints
+			pdps = sfxproto.NewDataPoints(pdps.Len()).Append(pdps)
+		}
+		pdps.Sort()
+	}
+
 	jsonBytes, err := pdps.Marshal()
 	if err != nil {
 		return ErrMarshal(err)
 	}
 
-	req, _ := http.NewRequest("POST", c.config.URL, bytes.NewBuffer(jsonBytes))
+	return c.submit(ctx, jsonBytes, nil)
+}
+
+// releaseBody is a request body which calls release once it is closed,
+// i.e. once the transport no longer uses it
+type releaseBody struct {
+	*bytes.Reader
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	b.once.Do(b.release)
+	return nil
+}
+
+// submit posts an encoded DataPointUploadMessage to SignalFx.  If
+// release is not nil, it is called once data is no longer used, which
+// may be after submit returns.
+func (c *Client) submit(ctx context.Context, data []byte, release func()) error {
+	if c.config.DebugDir != "" {
+		c.writeDebug(data)
+	}
+
+	var reqBody io.Reader = bytes.NewReader(data)
+	if release != nil {
+		reqBody = &releaseBody{Reader: bytes.NewReader(data), release: release}
+	}
+
+	req, _ := http.NewRequest("POST", c.config.URL, reqBody)
+	req.ContentLength = int64(len(data))
 	req.Header = http.Header{
 		TokenHeader:    {c.config.AuthToken},
 		"User-Agent":   {c.config.UserAgent},
@@ -56,7 +146,10 @@ func (c *Client) Submit(ctx context.Context, pdps *sfxproto.DataPoints) error {
 		"Content-Type": {"application/x-protobuf"},
 	}
 
-	var resp *http.Response
+	var (
+		resp *http.Response
+		err  error
+	)
 	done := make(chan interface{}, 1)
 
 	go func() {
@@ -66,12 +159,12 @@ func (c *Client) Submit(ctx context.Context, pdps *sfxproto.DataPoints) error {
 
 	select {
 	case <-ctx.Done():
-		if tr, ok := c.tr.(*http.Transport); ok {
+		if tr, ok := c.tr.(canceler); ok {
 			tr.CancelRequest(req)
 			<-done // wait for the request to be canceled
 		} else {
 			if c.config.Logger != nil {
-				fmt.Fprintf(c.config.Logger, "tried to cancel non-cancellable transport %T", tr)
+				fmt.Fprintf(c.config.Logger, "tried to cancel non-cancellable transport %T", c.tr)
 			}
 		}
 		return ErrContext(ctx.Err())
@@ -1,9 +1,13 @@
 package signalfx
 
 import (
+	"bytes"
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/golang/protobuf/proto"
@@ -76,6 +80,85 @@ func TestClient(t *testing.T) {
 			So(err, ShouldBeNil)
 		})
 
+		Convey("submit should write payloads to the debug directory", func() {
+			dir, err := ioutil.TempDir("", "sfx-debug")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			config := config.Clone()
+			config.DebugDir = dir
+			client := NewClient(config)
+			So(client.Submit(context.Background(), pdps), ShouldBeNil)
+			client.debugWG.Wait()
+
+			files, err := ioutil.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(len(files), ShouldEqual, 1)
+
+			data, err := ioutil.ReadFile(filepath.Join(dir, files[0].Name()))
+			So(err, ShouldBeNil)
+			msg, err := sfxproto.Decode(data)
+			So(err, ShouldBeNil)
+			So(len(msg.Datapoints), ShouldEqual, 1)
+			So(msg.Datapoints[0].GetMetric(), ShouldEqual, "TestClient")
+		})
+
+		Convey("submit should drop debug payloads while the disk is behind", func() {
+			dir, err := ioutil.TempDir("", "sfx-debug")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			var log bytes.Buffer
+			config := config.Clone()
+			config.DebugDir = dir
+			config.Logger = &log
+			client := NewClient(config)
+			for i := 0; i < maxDebugWrites; i++ {
+				client.debugWrites <- struct{}{}
+			}
+			So(client.Submit(context.Background(), pdps), ShouldBeNil)
+			client.debugWG.Wait()
+
+			files, err := ioutil.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(files, ShouldBeEmpty)
+			So(log.String(), ShouldContainSubstring, "dropped debug payload")
+		})
+
+				Convey("submit should sort and coalesce datapoints if configured", func() {
+			dir, err := ioutil.TempDir("", "sfx-debug")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			config := config.Clone()
+			config.DebugDir = dir
+			config.SortDataPoints = true
+			config.CoalesceDataPoints = true
+
+			pdps := sfxproto.NewDataPoints(3)
+			for _, m := range []string{"b", "a", "b"} {
+				pdps.Add(&sfxproto.DataPoint{
+					Metric:     proto.String(m),
+					MetricType: sfxproto.MetricType_COUNTER.Enum(),
+					Value:      &sfxproto.Datum{IntValue: proto.Int64(1)},
+				})
+			}
+			client := NewClient(config)
+			So(client.Submit(context.Background(), pdps), ShouldBeNil)
+			client.debugWG.Wait()
+			So(pdps.List()[0].GetMetric(), ShouldEqual, "b")
+
+			files, err := ioutil.ReadDir(dir)
+			So(err, ShouldBeNil)
+			data, err := ioutil.ReadFile(filepath.Join(dir, files[0].Name()))
+			So(err, ShouldBeNil)
+			msg, err := sfxproto.Decode(data)
+			So(err, ShouldBeNil)
+			So(len(msg.Datapoints), ShouldEqual, 2)
+			So(msg.Datapoints[0].GetMetric(), ShouldEqual, "a")
+			So(msg.Datapoints[1].GetValue().GetIntValue(), ShouldEqual, 2)
+		})
+
 		Convey("submit should handle a previously canceled context", func() {
 			ctx, cancelF := context.WithCancel(context.Background())
 			cancelF()
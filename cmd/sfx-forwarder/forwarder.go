@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"zvelo.io/go-signalfx"
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+// jsonDataPoint is a single datapoint of the SignalFx JSON format
+type jsonDataPoint struct {
+	Metric     string            `json:"metric"`
+	Value      json.Number       `json:"value"`
+	Dimensions map[string]string `json:"dimensions"`
+	Timestamp  int64             `json:"timestamp"`
+}
+
+// jsonTypes maps the keys of the SignalFx JSON format to metric types
+var jsonTypes = map[string]sfxproto.MetricType{
+	"gauge":              sfxproto.MetricType_GAUGE,
+	"counter":            sfxproto.MetricType_COUNTER,
+	"cumulative_counter": sfxproto.MetricType_CUMULATIVE_COUNTER,
+}
+
+// decodeJSON decodes a SignalFx JSON datapoint upload, e.g.
+// {"gauge": [{"metric": "m", "value": 1, "dimensions": {"a": "b"}}]}
+func decodeJSON(body []byte) ([]*sfxproto.DataPoint, error) {
+	var msg map[string][]jsonDataPoint
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&msg); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var ret []*sfxproto.DataPoint
+	for key, jdps := range msg {
+		typ, ok := jsonTypes[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown metric type %q", key)
+		}
+
+		for _, jdp := range jdps {
+			dp := &sfxproto.DataPoint{
+				Metric:     proto.String(jdp.Metric),
+				MetricType: typ.Enum(),
+				Dimensions: sfxproto.Dimensions(jdp.Dimensions).List(),
+				Value:      &sfxproto.Datum{},
+			}
+
+			if i, err := jdp.Value.Int64(); err == nil {
+				dp.Value.IntValue = proto.Int64(i)
+			} else if f, err := jdp.Value.Float64(); err == nil {
+				dp.Value.DoubleValue = proto.Float64(f)
+			} else {
+				return nil, fmt.Errorf("invalid value %q for %s", jdp.Value, jdp.Metric)
+			}
+
+			if jdp.Timestamp > 0 {
+				dp.Timestamp = proto.Int64(jdp.Timestamp)
+			} else {
+				dp.SetTime(now)
+			}
+
+			ret = append(ret, dp)
+		}
+	}
+	return ret, nil
+}
+
+// A forwarder accepts datapoints, buffers them and forwards them upstream
+// in batches. Datapoints are buffered in memory only; once maxBuffer is
+// reached, the oldest are dropped. Uploads of more than maxBody bytes,
+// after decompression, are rejected.
+type forwarder struct {
+	url       string
+	token     string
+	userAgent string
+	client    *http.Client
+	batchSize int
+	maxBuffer int
+	maxBody   int64
+	retries   int
+	backoff   time.Duration
+	logger    io.Writer
+
+	mu      sync.Mutex
+	buf     []*sfxproto.DataPoint
+	dropped uint64
+	wake    chan struct{}
+}
+
+func newForwarder(url, token string) *forwarder {
+	return &forwarder{
+		url:       url,
+		token:     token,
+		userAgent: signalfx.DefaultUserAgent + " sfx-forwarder",
+		client:    &http.Client{Timeout: signalfx.DefaultTimeoutDuration},
+		batchSize: 1000,
+		maxBuffer: 100000,
+		maxBody:   10 << 20,
+		retries:   3,
+		backoff:   time.Second,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+func (f *forwarder) logf(format string, args ...interface{}) {
+	if f.logger != nil {
+		fmt.Fprintf(f.logger, format+"\n", args...)
+	}
+}
+
+// ServeHTTP accepts a /v2/datapoint upload, in either protobuf or JSON.
+// Any token sent by the client is ignored; the forwarder's own token is
+// used upstream. Uploads containing any datapoint which SignalFx would
+// reject are rejected whole, so that a bad client cannot cause the
+// batches of others to be rejected upstream.
+func (f *forwarder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body io.ReadCloser = http.MaxBytesReader(w, r.Body, f.maxBody)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = http.MaxBytesReader(w, gz, f.maxBody)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var dps []*sfxproto.DataPoint
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		dps, err = decodeJSON(data)
+	} else {
+		var msg sfxproto.DataPointUploadMessage
+		err = proto.Unmarshal(data, &msg)
+		dps = msg.Datapoints
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for i, dp := range dps {
+		if problems := dp.Problems(); len(problems) > 0 {
+			http.Error(w, fmt.Sprintf("datapoint %d (%s): %s", i, dp.GetMetric(), strings.Join(problems, "; ")), http.StatusBadRequest)
+			return
+		}
+	}
+
+	f.enqueue(dps)
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`"OK"`))
+}
+
+// enqueue adds dps to the end of the buffer
+func (f *forwarder) enqueue(dps []*sfxproto.DataPoint) {
+	f.mu.Lock()
+	f.buf = append(f.buf, dps...)
+	f.trim()
+	full := len(f.buf) >= f.batchSize
+	f.mu.Unlock()
+
+	if full {
+		select {
+		case f.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// requeue returns dps, which failed to be sent, to the front of the
+// buffer
+func (f *forwarder) requeue(dps []*sfxproto.DataPoint) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.buf = append(dps, f.buf...)
+	f.trim()
+}
+
+// trim drops the oldest datapoints beyond maxBuffer. f.mu must be held.
+func (f *forwarder) trim() {
+	if over := len(f.buf) - f.maxBuffer; over > 0 {
+		f.buf = f.buf[over:]
+		f.dropped += uint64(over)
+		f.logf("buffer full, dropped %d datapoints", over)
+	}
+}
+
+// take removes up to batchSize datapoints from the front of the buffer
+func (f *forwarder) take() []*sfxproto.DataPoint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := len(f.buf)
+	if n > f.batchSize {
+		n = f.batchSize
+	}
+	ret := f.buf[:n:n]
+	f.buf = f.buf[n:]
+	return ret
+}
+
+// buffered returns the number of buffered datapoints
+func (f *forwarder) buffered() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.buf)
+}
+
+// retryable returns whether a failed upload should be retried
+func retryable(err error) bool {
+	if status, ok := err.(*signalfx.ErrStatus); ok {
+		return status.StatusCode >= 500 || status.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// post sends a single gzip compressed batch upstream
+func (f *forwarder) post(ctx context.Context, data []byte) error {
+	req, err := http.NewRequest("POST", f.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header = http.Header{
+		signalfx.TokenHeader: {f.token},
+		"User-Agent":         {f.userAgent},
+		"Content-Type":       {"application/x-protobuf"},
+		"Content-Encoding":   {"gzip"},
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return &signalfx.ErrStatus{Body: body, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// send uploads dps, retrying with exponential backoff
+func (f *forwarder) send(ctx context.Context, dps []*sfxproto.DataPoint) error {
+	data, err := proto.Marshal(&sfxproto.DataPointUploadMessage{Datapoints: dps})
+	if err != nil {
+		return err
+	}
+
+	var gzBuf bytes.Buffer
+	gz := gzip.NewWriter(&gzBuf)
+	if _, err = gz.Write(data); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+
+	backoff := f.backoff
+	for attempt := 0; ; attempt++ {
+		if err = f.post(ctx, gzBuf.Bytes()); err == nil || !retryable(err) || attempt >= f.retries {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// flush sends every buffered datapoint, returning the first error. Batches
+// which fail with a retryable error are requeued.
+func (f *forwarder) flush(ctx context.Context) error {
+	for {
+		dps := f.take()
+		if len(dps) == 0 {
+			return nil
+		}
+
+		if err := f.send(ctx, dps); err != nil {
+			if retryable(err) {
+				f.requeue(dps)
+			} else {
+				f.logf("dropped %d datapoints: %v", len(dps), err)
+			}
+			return err
+		}
+	}
+}
+
+// run flushes every interval, or whenever a full batch is buffered, until
+// ctx is done
+func (f *forwarder) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-f.wake:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := f.flush(ctx); err != nil {
+			f.logf("failed to forward datapoints: %v", err)
+		}
+	}
+}
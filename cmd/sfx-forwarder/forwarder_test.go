@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+	"zvelo.io/go-signalfx"
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+func TestForwarder(t *testing.T) {
+	Convey("Testing sfx-forwarder", t, func() {
+		var (
+			mu       sync.Mutex
+			received []*sfxproto.DataPoint
+			statuses []int
+			tokens   []string
+		)
+
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			tokens = append(tokens, r.Header.Get(signalfx.TokenHeader))
+			if len(statuses) > 0 {
+				status := statuses[0]
+				statuses = statuses[1:]
+				w.WriteHeader(status)
+				return
+			}
+
+			// assertions can't be made outside of the test's
+			// goroutine, so failures are returned as a 418
+			if r.Header.Get("Content-Encoding") != "gzip" {
+				w.WriteHeader(http.StatusTeapot)
+				return
+			}
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusTeapot)
+				return
+			}
+			data, _ := ioutil.ReadAll(gz)
+
+			var msg sfxproto.DataPointUploadMessage
+			if err = proto.Unmarshal(data, &msg); err != nil {
+				w.WriteHeader(http.StatusTeapot)
+				return
+			}
+			received = append(received, msg.Datapoints...)
+			_, _ = w.Write([]byte(`"OK"`))
+		}))
+		defer upstream.Close()
+
+		f := newForwarder(upstream.URL, "upstream-token")
+		f.backoff = time.Millisecond
+		srv := httptest.NewServer(f)
+		defer srv.Close()
+
+		Convey("a Reporter should report through it without a token", func() {
+			reporter := signalfx.NewReporter(signalfx.NewForwarderConfig(srv.URL), map[string]string{"host": "a"})
+			So(reporter.Record("gauge", nil, 5), ShouldBeNil)
+			So(reporter.Inc("counter", nil, 2), ShouldBeNil)
+			_, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(f.buffered(), ShouldEqual, 2)
+
+			So(f.flush(context.Background()), ShouldBeNil)
+			So(f.buffered(), ShouldEqual, 0)
+			So(len(received), ShouldEqual, 2)
+			So(tokens, ShouldResemble, []string{"upstream-token"})
+			for _, dp := range received {
+				So(sfxproto.NewDimensions(dp.Dimensions)["host"], ShouldEqual, "a")
+			}
+		})
+
+		Convey("JSON should be accepted", func() {
+			resp, err := http.Post(srv.URL+"/v2/datapoint", "application/json", bytes.NewBufferString(`{
+				"gauge": [{"metric": "g", "value": 1.5, "dimensions": {"a": "b"}, "timestamp": 1000}],
+				"cumulative_counter": [{"metric": "c", "value": 10}]
+			}`))
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			So(f.flush(context.Background()), ShouldBeNil)
+			So(len(received), ShouldEqual, 2)
+			for _, dp := range received {
+				switch dp.GetMetric() {
+				case "g":
+					So(dp.GetMetricType(), ShouldEqual, sfxproto.MetricType_GAUGE)
+					So(dp.GetValue().GetDoubleValue(), ShouldEqual, 1.5)
+					So(dp.GetTimestamp(), ShouldEqual, 1000)
+					So(sfxproto.NewDimensions(dp.Dimensions), ShouldResemble, sfxproto.Dimensions{"a": "b"})
+				case "c":
+					So(dp.GetMetricType(), ShouldEqual, sfxproto.MetricType_CUMULATIVE_COUNTER)
+					So(dp.GetValue().GetIntValue(), ShouldEqual, 10)
+					So(dp.GetTimestamp(), ShouldBeGreaterThan, 0)
+				}
+			}
+
+			resp, err = http.Post(srv.URL+"/v2/datapoint", "application/json", bytes.NewBufferString(`{"enum": []}`))
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("invalid datapoints should be rejected", func() {
+			resp, err := http.Post(srv.URL+"/v2/datapoint", "application/json", bytes.NewBufferString(`{
+				"gauge": [{"metric": "g", "value": 1}, {"metric": "h", "value": 1, "dimensions": {"sf_x": "y"}}]
+			}`))
+			So(err, ShouldBeNil)
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(string(body), ShouldContainSubstring, "reserved dimension key")
+			So(f.buffered(), ShouldEqual, 0)
+		})
+
+		Convey("large uploads should be rejected", func() {
+			f.maxBody = 50
+
+			var gzBuf bytes.Buffer
+			gz := gzip.NewWriter(&gzBuf)
+			gz.Write(bytes.Repeat([]byte(" "), 100))
+			gz.Close()
+			So(gzBuf.Len(), ShouldBeLessThan, 50)
+
+			for _, body := range [][]byte{bytes.Repeat([]byte(" "), 100), gzBuf.Bytes()} {
+				req, _ := http.NewRequest("POST", srv.URL+"/v2/datapoint", bytes.NewReader(body))
+				if len(body) < 100 {
+					req.Header.Set("Content-Encoding", "gzip")
+				}
+				resp, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+			}
+		})
+
+		dp := func(metric string) *sfxproto.DataPoint {
+			return &sfxproto.DataPoint{
+				Metric:     proto.String(metric),
+				MetricType: sfxproto.MetricType_GAUGE.Enum(),
+				Value:      &sfxproto.Datum{IntValue: proto.Int64(1)},
+			}
+		}
+
+		Convey("failed requests should be retried", func() {
+			statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+			f.enqueue([]*sfxproto.DataPoint{dp("a")})
+			So(f.flush(context.Background()), ShouldBeNil)
+			So(len(received), ShouldEqual, 1)
+			So(len(tokens), ShouldEqual, 3)
+		})
+
+		Convey("batches should be requeued once retries are exhausted", func() {
+			f.retries = 1
+			statuses = []int{http.StatusBadGateway, http.StatusBadGateway}
+			f.enqueue([]*sfxproto.DataPoint{dp("a"), dp("b")})
+			So(f.flush(context.Background()), ShouldNotBeNil)
+			So(f.buffered(), ShouldEqual, 2)
+
+			So(f.flush(context.Background()), ShouldBeNil)
+			So(len(received), ShouldEqual, 2)
+			So(received[0].GetMetric(), ShouldEqual, "a")
+		})
+
+		Convey("rejected batches should be dropped", func() {
+			statuses = []int{http.StatusBadRequest}
+			f.enqueue([]*sfxproto.DataPoint{dp("a")})
+			So(f.flush(context.Background()), ShouldNotBeNil)
+			So(f.buffered(), ShouldEqual, 0)
+			So(len(tokens), ShouldEqual, 1)
+		})
+
+		Convey("the buffer should be bounded and batched", func() {
+			f.maxBuffer = 3
+			f.batchSize = 2
+			f.enqueue([]*sfxproto.DataPoint{dp("a"), dp("b"), dp("c"), dp("d")})
+			So(f.buffered(), ShouldEqual, 3)
+			So(f.dropped, ShouldEqual, 1)
+
+			So(f.flush(context.Background()), ShouldBeNil)
+			So(len(tokens), ShouldEqual, 2)
+			So(received[0].GetMetric(), ShouldEqual, "b")
+		})
+	})
+}
@@ -0,0 +1,84 @@
+// Command sfx-forwarder is a local agent which accepts datapoints on
+// /v2/datapoint, in protobuf or JSON, and forwards them to SignalFx in
+// gzip compressed batches using a single token, retrying and buffering
+// them while SignalFx is unreachable.
+//
+//	SFX_API_TOKEN=... sfx-forwarder -addr 127.0.0.1:9080
+//
+// Processes may then report through it without a token of their own:
+//
+//	reporter := signalfx.NewReporter(signalfx.NewForwarderConfig(""), nil)
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+	"zvelo.io/go-signalfx"
+)
+
+func main() {
+	f := newForwarder(signalfx.DefaultURL, os.Getenv("SFX_API_TOKEN"))
+	f.logger = os.Stderr
+
+	addr := flag.String("addr", signalfx.DefaultForwarderAddr, "address to accept datapoints on")
+	interval := flag.Duration("interval", time.Second, "maximum time datapoints are held before being forwarded")
+	drain := flag.Duration("drain", 10*time.Second, "maximum time spent forwarding buffered datapoints on exit")
+	flag.StringVar(&f.url, "url", f.url, "SignalFx ingest URL")
+	flag.IntVar(&f.batchSize, "batch", f.batchSize, "maximum datapoints forwarded per request")
+	flag.IntVar(&f.maxBuffer, "buffer", f.maxBuffer, "maximum datapoints buffered; the oldest are dropped beyond this")
+	flag.Int64Var(&f.maxBody, "max-body", f.maxBody, "maximum bytes of an upload, after decompression")
+	flag.IntVar(&f.retries, "retries", f.retries, "retries of a failed request before it is requeued")
+	flag.DurationVar(&f.backoff, "backoff", f.backoff, "initial delay between retries, doubled each time")
+	flag.Parse()
+
+	if f.token == "" {
+		fmt.Fprintln(os.Stderr, "SFX_API_TOKEN is not set")
+		os.Exit(2)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/v2/datapoint", f)
+	srv := &http.Server{Addr: *addr, Handler: mux}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.run(ctx, *interval)
+		close(done)
+	}()
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+
+	status := 0
+	select {
+	case err := <-errc:
+		fmt.Fprintln(os.Stderr, "failed to serve:", err)
+		status = 1
+	case <-sigc:
+	}
+
+	_ = srv.Close()
+	cancel()
+	<-done
+
+	// forward whatever is still buffered
+	ctx, cancel = context.WithTimeout(context.Background(), *drain)
+	err := f.flush(ctx)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to forward %d buffered datapoints: %v\n", f.buffered(), err)
+		status = 1
+	}
+	os.Exit(status)
+}
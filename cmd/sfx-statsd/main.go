@@ -0,0 +1,88 @@
+// Command sfx-statsd is a local agent which receives StatsD metrics and
+// reports them to SignalFx.
+//
+//	SFX_API_TOKEN=... sfx-statsd -addr :8125 -dim host=$(hostname)
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+	"zvelo.io/go-signalfx"
+	"zvelo.io/go-signalfx/sfxproto"
+	"zvelo.io/go-signalfx/statsd"
+)
+
+// dimensions is a flag.Value collecting key=value pairs
+type dimensions map[string]string
+
+func (d dimensions) String() string {
+	pairs := make([]string, 0, len(d))
+	for k, v := range d {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (d dimensions) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid dimension %q, expected key=value", s)
+	}
+	d[s[:i]] = s[i+1:]
+	return nil
+}
+
+func main() {
+	dims := dimensions{}
+	config := signalfx.NewConfig()
+
+	network := flag.String("network", "udp", `network to listen on, "udp" or "unixgram"`)
+	addr := flag.String("addr", ":8125", "address (or socket path) to listen on")
+	interval := flag.Duration("interval", 10*time.Second, "reporting interval")
+	prefix := flag.String("prefix", "", "prefix added to every metric name")
+	flag.StringVar(&config.URL, "url", config.URL, "SignalFx ingest URL")
+	flag.Var(dims, "dim", "key=value dimension added to every metric (may be repeated)")
+	flag.Parse()
+
+	if config.AuthToken == "" {
+		fmt.Fprintln(os.Stderr, "SFX_API_TOKEN is not set")
+		os.Exit(2)
+	}
+	config.Logger = os.Stderr
+
+	reporter := signalfx.NewReporter(config, dims)
+	reporter.SetPrefix(*prefix)
+	srv := statsd.NewServer(reporter, nil)
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe(*network, *addr) }()
+	cancel := reporter.RunInBackground(*interval)
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+
+	status := 0
+	select {
+	case err := <-errc:
+		fmt.Fprintln(os.Stderr, "failed to serve:", err)
+		status = 1
+	case <-sigc:
+	}
+
+	cancel()
+
+	// report whatever was received since the last interval
+	if _, err := reporter.Report(context.Background()); err != nil && err != sfxproto.ErrMarshalNoData {
+		fmt.Fprintln(os.Stderr, "failed to report:", err)
+		status = 1
+	}
+	_ = srv.Close()
+	os.Exit(status)
+}
@@ -0,0 +1,187 @@
+// Command sfxdump decodes captured SignalFx datapoint uploads and prints
+// them as a table or as JSON, flagging anything SignalFx would reject and
+// counting the datapoints of each metric.
+//
+// Its input may be the protobuf payload itself (such as those written by a
+// Client configured with a DebugDir), gzip compressed or not, or a complete
+// HTTP request. It reads the named files, or stdin if there are none:
+//
+//	sfxdump /tmp/sfx-debug/*.pb
+//	sfxdump -json < request.bin
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+// dumpDataPoint is the JSON representation of a decoded datapoint
+type dumpDataPoint struct {
+	Source     string            `json:"source"`
+	Metric     string            `json:"metric"`
+	Type       string            `json:"type"`
+	Value      interface{}       `json:"value"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+	Problems   []string          `json:"problems,omitempty"`
+}
+
+// dump is the JSON output of sfxdump
+type dump struct {
+	DataPoints []dumpDataPoint `json:"datapoints"`
+	Counts     map[string]int  `json:"counts"`
+	Invalid    int             `json:"invalid"`
+}
+
+// value returns whichever value of d is set
+func value(d *sfxproto.Datum) interface{} {
+	switch {
+	case d == nil:
+		return nil
+	case d.IntValue != nil:
+		return d.GetIntValue()
+	case d.DoubleValue != nil:
+		return d.GetDoubleValue()
+	case d.StrValue != nil:
+		return d.GetStrValue()
+	}
+	return nil
+}
+
+// decode decodes the datapoints of a single payload
+func decode(source string, data []byte) ([]dumpDataPoint, error) {
+	msg, err := sfxproto.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+
+	ret := make([]dumpDataPoint, len(msg.Datapoints))
+	for i, dp := range msg.Datapoints {
+		ret[i] = dumpDataPoint{
+			Source:     source,
+			Metric:     dp.GetMetric(),
+			Type:       dp.GetMetricType().String(),
+			Value:      value(dp.GetValue()),
+			Timestamp:  dp.Time().UTC(),
+			Dimensions: sfxproto.NewDimensions(dp.Dimensions),
+			Problems:   dp.Problems(),
+		}
+	}
+	return ret, nil
+}
+
+// summarize counts the datapoints of each metric, and those which are
+// invalid
+func summarize(dps []dumpDataPoint) *dump {
+	ret := &dump{DataPoints: dps, Counts: map[string]int{}}
+	for _, dp := range dps {
+		ret.Counts[dp.Metric]++
+		if len(dp.Problems) > 0 {
+			ret.Invalid++
+		}
+	}
+	return ret
+}
+
+// formatDimensions returns dims as a sorted, comma-separated list of
+// key=value pairs
+func formatDimensions(dims map[string]string) string {
+	pairs := make([]string, 0, len(dims))
+	for k, v := range dims {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// writeTable renders d as tables of datapoints and of per-metric counts
+func writeTable(w io.Writer, d *dump) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tMETRIC\tTYPE\tVALUE\tTIMESTAMP\tDIMENSIONS\tPROBLEMS")
+	for _, dp := range d.DataPoints {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%s\t%s\t%s\n",
+			dp.Source, dp.Metric, dp.Type, dp.Value, dp.Timestamp.Format(time.RFC3339Nano),
+			formatDimensions(dp.Dimensions), strings.Join(dp.Problems, "; "))
+	}
+
+	metrics := make([]string, 0, len(d.Counts))
+	for metric := range d.Counts {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "METRIC\tCOUNT")
+	for _, metric := range metrics {
+		fmt.Fprintf(tw, "%s\t%d\n", metric, d.Counts[metric])
+	}
+	fmt.Fprintf(tw, "total\t%d\n", len(d.DataPoints))
+	fmt.Fprintf(tw, "invalid\t%d\n", d.Invalid)
+	return tw.Flush()
+}
+
+func main() {
+	asJSON := flag.Bool("json", false, "print JSON instead of tables")
+	flag.Parse()
+
+	sources := flag.Args()
+	if len(sources) == 0 {
+		sources = []string{"-"}
+	}
+
+	status := 0
+	var dps []dumpDataPoint
+	for _, source := range sources {
+		var (
+			data []byte
+			err  error
+		)
+		if source == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(source)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "sfxdump:", err)
+			status = 1
+			continue
+		}
+
+		decoded, err := decode(source, data)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "sfxdump:", err)
+			status = 1
+			continue
+		}
+		dps = append(dps, decoded...)
+	}
+
+	d := summarize(dps)
+	var err error
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(d)
+	} else {
+		err = writeTable(os.Stdout, d)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sfxdump:", err)
+		status = 1
+	}
+
+	if d.Invalid > 0 && status == 0 {
+		status = 3
+	}
+	os.Exit(status)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/smartystreets/goconvey/convey"
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+func TestDump(t *testing.T) {
+	Convey("Testing sfxdump", t, func() {
+		data, err := proto.Marshal(&sfxproto.DataPointUploadMessage{Datapoints: []*sfxproto.DataPoint{{
+			Metric:     proto.String("requests"),
+			MetricType: sfxproto.MetricType_COUNTER.Enum(),
+			Value:      &sfxproto.Datum{IntValue: proto.Int64(3)},
+			Dimensions: sfxproto.Dimensions{"host": "a"}.List(),
+			Timestamp:  proto.Int64(1000),
+		}, {
+			Metric:     proto.String("requests"),
+			MetricType: sfxproto.MetricType_COUNTER.Enum(),
+			Value:      &sfxproto.Datum{IntValue: proto.Int64(4)},
+			Timestamp:  proto.Int64(1000),
+		}, {
+			Metric:     proto.String("load"),
+			MetricType: sfxproto.MetricType_GAUGE.Enum(),
+			Timestamp:  proto.Int64(1000),
+		}}})
+		So(err, ShouldBeNil)
+
+		dps, err := decode("payload.pb", data)
+		So(err, ShouldBeNil)
+		So(len(dps), ShouldEqual, 3)
+		So(dps[0].Value, ShouldEqual, 3)
+		So(dps[0].Type, ShouldEqual, "COUNTER")
+		So(dps[0].Dimensions, ShouldResemble, map[string]string{"host": "a"})
+		So(dps[2].Value, ShouldBeNil)
+		So(dps[2].Problems, ShouldResemble, []string{"no value"})
+
+		d := summarize(dps)
+		So(d.Counts, ShouldResemble, map[string]int{"requests": 2, "load": 1})
+		So(d.Invalid, ShouldEqual, 1)
+
+		var buf bytes.Buffer
+		So(writeTable(&buf, d), ShouldBeNil)
+		So(buf.String(), ShouldContainSubstring, "payload.pb  requests  COUNTER  3      1970-01-01T00:00:01Z  host=a")
+		So(buf.String(), ShouldContainSubstring, "no value")
+		So(buf.String(), ShouldContainSubstring, "invalid   1")
+
+		_, err = decode("bad.pb", []byte{0xff})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldStartWith, "bad.pb: ")
+	})
+}
@@ -0,0 +1,222 @@
+// Command sfxsend sends datapoints or events to SignalFx, for use from cron
+// jobs and scripts. The token is read from $SFX_API_TOKEN.
+//
+// A single datapoint may be given as arguments:
+//
+//	sfxsend backup-duration-s gauge 42 host=db-1
+//
+// Otherwise datapoints are read from stdin, one per line, either as
+// "metric type value [dim=val ...]" or as JSON:
+//
+//	{"metric": "backup-bytes", "type": "counter", "value": 1024, "dimensions": {"host": "db-1"}}
+//
+// With -event, events are sent instead, as "eventType [dim=val ...]" or as
+// JSON with eventType, category, dimensions, properties and timestamp:
+//
+//	sfxsend -event -prop version=1.2.3 deploy service=api
+//
+// With -dry-run, nothing is sent: the payload which would be POSTed is
+// printed instead, as a hex dump of the protobuf-encoded datapoints, or as
+// the JSON of the events.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"zvelo.io/go-signalfx"
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+// pairs is a flag.Value collecting key=value pairs
+type pairs map[string]string
+
+func (p pairs) String() string {
+	ret := make([]string, 0, len(p))
+	for k, v := range p {
+		ret = append(ret, k+"="+v)
+	}
+	return strings.Join(ret, ",")
+}
+
+func (p pairs) Set(s string) error {
+	dims, err := parseDims([]string{s})
+	for k, v := range dims {
+		p[k] = v
+	}
+	return err
+}
+
+// lines returns args as a single line, or else the lines of r
+func lines(args []string, r io.Reader) ([]string, error) {
+	if len(args) > 0 {
+		return []string{strings.Join(args, " ")}, nil
+	}
+
+	var ret []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			ret = append(ret, line)
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// eventURL returns the event endpoint alongside a datapoint URL
+func eventURL(datapointURL string) string {
+	if strings.HasSuffix(datapointURL, "/v2/datapoint") {
+		return strings.TrimSuffix(datapointURL, "/v2/datapoint") + "/v2/event"
+	}
+	return strings.TrimSuffix(datapointURL, "/") + "/v2/event"
+}
+
+// payloadRoundTripper records the body of a request instead of sending
+// it, answering as SignalFx would
+type payloadRoundTripper struct {
+	body []byte
+}
+
+func (rt *payloadRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var err error
+	if req.Body != nil {
+		rt.body, err = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(`"OK"`)),
+		Request:    req,
+	}, err
+}
+
+// payload returns the body which submitting pdps with config would POST
+func payload(ctx context.Context, config *signalfx.Config, pdps *sfxproto.DataPoints) ([]byte, error) {
+	rt := &payloadRoundTripper{}
+	config = config.Clone()
+	config.RoundTripper = rt
+	if err := signalfx.NewClient(config).Submit(ctx, pdps); err != nil {
+		return nil, err
+	}
+	return rt.body, nil
+}
+
+// sendEvents posts events as JSON
+func sendEvents(ctx context.Context, config *signalfx.Config, url string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header = http.Header{
+		signalfx.TokenHeader: {config.AuthToken},
+		"User-Agent":         {config.UserAgent},
+		"Content-Type":       {"application/json"},
+	}
+
+	resp, err := (&http.Client{Transport: config.Transport()}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return &signalfx.ErrStatus{Body: respBody, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+func main() {
+	dims := pairs{}
+	props := pairs{}
+	config := signalfx.NewConfig()
+
+	isEvent := flag.Bool("event", false, "send events instead of datapoints")
+	dryRun := flag.Bool("dry-run", false, "print the payload which would be sent, as a hex dump for datapoints, instead of sending it")
+	category := flag.String("category", "USER_DEFINED", "category of events")
+	evURL := flag.String("event-url", "", "SignalFx event URL (default derived from -url)")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout for sending")
+	flag.StringVar(&config.URL, "url", config.URL, "SignalFx datapoint URL")
+	flag.Var(dims, "dim", "key=value dimension added to everything sent (may be repeated)")
+	flag.Var(props, "prop", "key=value property added to events (may be repeated)")
+	flag.Parse()
+
+	fail := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, "sfxsend: "+format+"\n", args...)
+		os.Exit(1)
+	}
+
+	input, err := lines(flag.Args(), os.Stdin)
+	if err != nil {
+		fail("%v", err)
+	}
+	if len(input) == 0 {
+		fail("nothing to send")
+	}
+	if config.AuthToken == "" && !*dryRun {
+		fail("SFX_API_TOKEN is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if *isEvent {
+		events := make([]*event, len(input))
+		for i, line := range input {
+			if events[i], err = parseEvent(line, dims, props, *category); err != nil {
+				fail("%v", err)
+			}
+		}
+
+		body, err := json.MarshalIndent(events, "", "  ")
+		if err != nil {
+			fail("%v", err)
+		}
+		if *dryRun {
+			fmt.Println(string(body))
+			return
+		}
+
+		if *evURL == "" {
+			*evURL = eventURL(config.URL)
+		}
+		if err = sendEvents(ctx, config, *evURL, body); err != nil {
+			fail("failed to send events: %v", err)
+		}
+		return
+	}
+
+	pdps := sfxproto.NewDataPoints(len(input))
+	for _, line := range input {
+		dp, err := parseDataPoint(line, dims)
+		if err != nil {
+			fail("%v", err)
+		}
+		pdps.Add(dp)
+	}
+
+	if *dryRun {
+		body, err := payload(ctx, config, pdps)
+		if err != nil {
+			fail("failed to encode datapoints: %v", err)
+		}
+		fmt.Print(hex.Dump(body))
+		return
+	}
+
+	if err = signalfx.NewClient(config).Submit(ctx, pdps); err != nil {
+		fail("failed to send datapoints: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+// metricTypes maps the types accepted on the command line to metric types
+var metricTypes = map[string]sfxproto.MetricType{
+	"gauge":              sfxproto.MetricType_GAUGE,
+	"counter":            sfxproto.MetricType_COUNTER,
+	"cumulative_counter": sfxproto.MetricType_CUMULATIVE_COUNTER,
+	"cumulative-counter": sfxproto.MetricType_CUMULATIVE_COUNTER,
+}
+
+// jsonDataPoint is a datapoint read from a JSON line
+type jsonDataPoint struct {
+	Metric     string            `json:"metric"`
+	Type       string            `json:"type"`
+	Value      json.Number       `json:"value"`
+	Dimensions map[string]string `json:"dimensions"`
+	Timestamp  int64             `json:"timestamp"`
+}
+
+// An event is a SignalFx event, as posted to /v2/event
+type event struct {
+	EventType  string            `json:"eventType"`
+	Category   string            `json:"category,omitempty"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+	Timestamp  int64             `json:"timestamp,omitempty"`
+}
+
+// parseDims parses key=value fields
+func parseDims(fields []string) (map[string]string, error) {
+	ret := make(map[string]string, len(fields))
+	for _, f := range fields {
+		i := strings.Index(f, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid dimension %q, expected key=value", f)
+		}
+		ret[f[:i]] = f[i+1:]
+	}
+	return ret, nil
+}
+
+// newDataPoint builds a datapoint, keeping integer values as integers
+func newDataPoint(metric, typ, value string, dims map[string]string, timestamp int64) (*sfxproto.DataPoint, error) {
+	if metric == "" {
+		return nil, fmt.Errorf("missing metric name")
+	}
+
+	t, ok := metricTypes[strings.ToLower(typ)]
+	if !ok {
+		return nil, fmt.Errorf("invalid metric type %q, expected gauge, counter or cumulative_counter", typ)
+	}
+
+	ret := &sfxproto.DataPoint{
+		Metric:     proto.String(metric),
+		MetricType: t.Enum(),
+		Dimensions: sfxproto.Dimensions(dims).List(),
+		Value:      &sfxproto.Datum{},
+	}
+
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		ret.Value.IntValue = proto.Int64(i)
+	} else if f, err := strconv.ParseFloat(value, 64); err == nil {
+		ret.Value.DoubleValue = proto.Float64(f)
+	} else {
+		return nil, fmt.Errorf("invalid value %q for %s", value, metric)
+	}
+
+	if timestamp > 0 {
+		ret.Timestamp = proto.Int64(timestamp)
+	} else {
+		ret.SetTime(time.Now())
+	}
+
+	return ret, nil
+}
+
+// parseDataPoint parses a datapoint from either a JSON object or the
+// fields metric type value [dim=val ...]. dims are added to it.
+func parseDataPoint(line string, dims map[string]string) (*sfxproto.DataPoint, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		var jdp jsonDataPoint
+		if err := json.Unmarshal([]byte(line), &jdp); err != nil {
+			return nil, err
+		}
+		return newDataPoint(jdp.Metric, jdp.Type, jdp.Value.String(),
+			sfxproto.Dimensions(dims).Append(jdp.Dimensions), jdp.Timestamp)
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid datapoint %q, expected: metric type value [dim=val ...]", line)
+	}
+	lineDims, err := parseDims(fields[3:])
+	if err != nil {
+		return nil, err
+	}
+	return newDataPoint(fields[0], fields[1], fields[2], sfxproto.Dimensions(dims).Append(lineDims), 0)
+}
+
+// parseEvent parses an event from either a JSON object or the fields
+// eventType [dim=val ...]. dims are added to it, and category and
+// properties are used unless it specifies its own.
+func parseEvent(line string, dims, properties map[string]string, category string) (*event, error) {
+	line = strings.TrimSpace(line)
+	ret := &event{}
+
+	if strings.HasPrefix(line, "{") {
+		if err := json.Unmarshal([]byte(line), ret); err != nil {
+			return nil, err
+		}
+	} else {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("missing event type")
+		}
+		ret.EventType = fields[0]
+
+		var err error
+		if ret.Dimensions, err = parseDims(fields[1:]); err != nil {
+			return nil, err
+		}
+	}
+
+	if ret.EventType == "" {
+		return nil, fmt.Errorf("missing event type")
+	}
+	ret.Dimensions = sfxproto.Dimensions(dims).Append(ret.Dimensions)
+	if ret.Properties == nil && len(properties) > 0 {
+		ret.Properties = properties
+	}
+	if ret.Category == "" {
+		ret.Category = category
+	}
+	if ret.Timestamp == 0 {
+		ret.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+
+	return ret, nil
+}
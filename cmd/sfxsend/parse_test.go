@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+	"zvelo.io/go-signalfx"
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+func TestParse(t *testing.T) {
+	Convey("Testing sfxsend parsing", t, func() {
+		dims := map[string]string{"host": "a", "job": "backup"}
+
+		Convey("datapoints should be parsed from fields", func() {
+			dp, err := parseDataPoint("backup-bytes counter 1024 job=nightly", dims)
+			So(err, ShouldBeNil)
+			So(dp.GetMetric(), ShouldEqual, "backup-bytes")
+			So(dp.GetMetricType(), ShouldEqual, sfxproto.MetricType_COUNTER)
+			So(dp.GetValue().GetIntValue(), ShouldEqual, 1024)
+			So(dp.GetTimestamp(), ShouldBeGreaterThan, 0)
+			So(sfxproto.NewDimensions(dp.Dimensions), ShouldResemble, sfxproto.Dimensions{"host": "a", "job": "nightly"})
+
+			dp, err = parseDataPoint("load GAUGE 0.5", nil)
+			So(err, ShouldBeNil)
+			So(dp.GetValue().IntValue, ShouldBeNil)
+			So(dp.GetValue().GetDoubleValue(), ShouldEqual, 0.5)
+
+			for _, line := range []string{"a gauge", "a histogram 1", "a gauge x", "a gauge 1 b", "a gauge 1 =b"} {
+				_, err = parseDataPoint(line, nil)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("datapoints should be parsed from JSON", func() {
+			dp, err := parseDataPoint(`{"metric": "m", "type": "cumulative_counter", "value": 7, "dimensions": {"job": "x"}, "timestamp": 1000}`, dims)
+			So(err, ShouldBeNil)
+			So(dp.GetMetric(), ShouldEqual, "m")
+			So(dp.GetMetricType(), ShouldEqual, sfxproto.MetricType_CUMULATIVE_COUNTER)
+			So(dp.GetValue().GetIntValue(), ShouldEqual, 7)
+			So(dp.GetTimestamp(), ShouldEqual, 1000)
+			So(sfxproto.NewDimensions(dp.Dimensions), ShouldResemble, sfxproto.Dimensions{"host": "a", "job": "x"})
+
+			_, err = parseDataPoint(`{"type": "gauge", "value": 1}`, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("events should be parsed", func() {
+			ev, err := parseEvent("deploy service=api", dims, map[string]string{"version": "1.2"}, "USER_DEFINED")
+			So(err, ShouldBeNil)
+			So(ev.EventType, ShouldEqual, "deploy")
+			So(ev.Category, ShouldEqual, "USER_DEFINED")
+			So(ev.Dimensions, ShouldResemble, map[string]string{"host": "a", "job": "backup", "service": "api"})
+			So(ev.Properties, ShouldResemble, map[string]string{"version": "1.2"})
+			So(ev.Timestamp, ShouldBeGreaterThan, 0)
+
+			ev, err = parseEvent(`{"eventType": "restart", "category": "ALERT", "properties": {"a": "b"}, "timestamp": 5}`, nil, map[string]string{"version": "1.2"}, "USER_DEFINED")
+			So(err, ShouldBeNil)
+			So(ev.Category, ShouldEqual, "ALERT")
+			So(ev.Properties, ShouldResemble, map[string]string{"a": "b"})
+			So(ev.Timestamp, ShouldEqual, 5)
+
+			_, err = parseEvent(`{"category": "ALERT"}`, nil, nil, "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("the dry-run payload should be what is POSTed", func() {
+			dp, err := parseDataPoint("backup-bytes counter 1024", dims)
+			So(err, ShouldBeNil)
+			pdps := sfxproto.NewDataPoints(1)
+			pdps.Add(dp)
+
+			var posted []byte
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				posted, _ = ioutil.ReadAll(r.Body)
+				_, _ = w.Write([]byte(`"OK"`))
+			}))
+			defer ts.Close()
+
+			config := signalfx.NewConfig()
+			config.URL = ts.URL
+			body, err := payload(context.Background(), config, pdps)
+			So(err, ShouldBeNil)
+			So(posted, ShouldBeNil)
+			So(config.RoundTripper, ShouldBeNil)
+
+			So(signalfx.NewClient(config).Submit(context.Background(), pdps), ShouldBeNil)
+			So(body, ShouldResemble, posted)
+
+			var msg sfxproto.DataPointUploadMessage
+			So(proto.Unmarshal(body, &msg), ShouldBeNil)
+			So(len(msg.Datapoints), ShouldEqual, 1)
+			So(msg.Datapoints[0].GetMetric(), ShouldEqual, "backup-bytes")
+		})
+
+		Convey("input should come from args or lines", func() {
+			in, err := lines([]string{"a", "gauge", "1"}, nil)
+			So(err, ShouldBeNil)
+			So(in, ShouldResemble, []string{"a gauge 1"})
+
+			in, err = lines(nil, strings.NewReader("a gauge 1\n\n# comment\nb counter 2\n"))
+			So(err, ShouldBeNil)
+			So(in, ShouldResemble, []string{"a gauge 1", "b counter 2"})
+
+			So(eventURL("https://ingest.signalfx.com/v2/datapoint"), ShouldEqual, "https://ingest.signalfx.com/v2/event")
+			So(eventURL("http://localhost:8080/"), ShouldEqual, "http://localhost:8080/v2/event")
+		})
+	})
+}
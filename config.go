@@ -27,6 +27,14 @@ const (
 
 	// DefaultUserAgent is the user agent sent to signalfx
 	DefaultUserAgent = "go-signalfx/" + ClientVersion
+
+	// DefaultForwarderAddr is the address on which sfx-forwarder
+	// listens by default
+	DefaultForwarderAddr = "127.0.0.1:9080"
+
+	// DefaultForwarderURL is the URL used to send datapoints to a local
+	// sfx-forwarder
+	DefaultForwarderURL = "http://" + DefaultForwarderAddr + "/v2/datapoint"
 )
 
 // Config is used to configure a Client. It should be created with New to have
@@ -39,6 +47,29 @@ type Config struct {
 	UserAgent             string
 	TLSInsecureSkipVerify bool
 	Logger                io.Writer
+
+	// RoundTripper, if set, is used by the Client instead of the
+	// http.Transport returned by Transport.  It may wrap that
+	// transport (e.g. with sfxhttp.Transport) in order to measure
+	// the Client's own ingest calls.
+	RoundTripper http.RoundTripper
+
+	// DebugDir, if set, is a directory to which the Client writes every
+	// payload it submits, one file per payload, so that it may be
+	// inspected with sfxdump.  Payloads are copied and written in the
+	// background; if the disk falls behind, they are dropped.  Failures
+	// to write are only logged.
+	DebugDir string
+
+	// SortDataPoints, if set, makes the Client sort the datapoints of
+	// each payload by metric and dimensions, and the dimensions of each
+	// datapoint by key, so that payloads are reproducible.
+	SortDataPoints bool
+
+	// CoalesceDataPoints, if set, makes the Client merge the datapoints
+	// of each payload which belong to the same time series, as done by
+	// sfxproto.DataPoints.Coalesce.
+	CoalesceDataPoints bool
 }
 
 // Clone makes a deep copy of a Config
@@ -71,3 +102,17 @@ func NewConfig() *Config {
 		AuthToken:          os.Getenv("SFX_API_TOKEN"),
 	}
 }
+
+// NewForwarderConfig generates a new Config for reporting through a local
+// sfx-forwarder at url, or at DefaultForwarderURL if url is empty.  No
+// AuthToken is set, since the forwarder supplies its own.
+func NewForwarderConfig(url string) *Config {
+	if url == "" {
+		url = DefaultForwarderURL
+	}
+
+	ret := NewConfig()
+	ret.URL = url
+	ret.AuthToken = ""
+	return ret
+}
@@ -67,5 +67,15 @@ func TestConfig(t *testing.T) {
 			So(c0.UserAgent, ShouldEqual, c1.UserAgent)
 			So(c0.TLSInsecureSkipVerify, ShouldEqual, c1.TLSInsecureSkipVerify)
 		})
+
+		Convey("forwarder config should have no token", func() {
+			c0 := NewForwarderConfig("")
+			So(c0.URL, ShouldEqual, DefaultForwarderURL)
+			So(c0.AuthToken, ShouldEqual, "")
+			So(c0.UserAgent, ShouldEqual, DefaultUserAgent)
+
+			c1 := NewForwarderConfig("http://localhost:1234/v2/datapoint")
+			So(c1.URL, ShouldEqual, "http://localhost:1234/v2/datapoint")
+		})
 	})
 }
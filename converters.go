@@ -2,6 +2,24 @@ package signalfx
 
 import "sync/atomic"
 
+// int64Getter is implemented by the Getters adapting GetterOfs, whose
+// values need not be boxed
+type int64Getter interface {
+	getInt64() (int64, error)
+}
+
+// getInt64 gets the value of g as an int64
+func getInt64(g Getter) (int64, error) {
+	if g, ok := g.(int64Getter); ok {
+		return g.getInt64()
+	}
+	val, err := g.Get()
+	if err != nil {
+		return 0, err
+	}
+	return toInt64(val)
+}
+
 func toInt64(val interface{}) (int64, error) {
 	switch tval := val.(type) {
 	case int:
@@ -95,15 +95,21 @@ func WrapCounter(
 	}
 }
 
+// WrapCounterOf returns a WrappedCounter wrapping value, as WrapCounter
+// does, so that the type of its value is checked at compile time.
+func WrapCounterOf[T Number](
+	metric string,
+	dimensions map[string]string,
+	value SubtractorOf[T],
+) *WrappedCounter {
+	return WrapCounter(metric, dimensions, AsSubtractor(value))
+}
+
 // DataPoint returns a DataPoint representing the current state of the
 // wrapped counter value.  If that current state is negative or zero,
 // then no DataPoint will be returned.
 func (c *WrappedCounter) DataPoint() *DataPoint {
-	gottenValue, err := c.value.Get()
-	if err != nil {
-		return nil
-	}
-	value, err := toInt64(gottenValue)
+	value, err := getInt64(c.value)
 	if err != nil {
 		return nil
 	}
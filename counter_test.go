@@ -81,4 +81,18 @@ func TestCounter(t *testing.T) {
 		c.PostReportHook(cdp.Value)
 		So(*i, ShouldEqual, 0)
 	})
+	Convey("Generic wrapped counters should behave as specified", t, func() {
+		val := NewAtomic[uint32](0)
+		c := WrapCounterOf[uint32]("counter", nil, val)
+		So(c.DataPoint(), ShouldBeNil)
+
+		val.Inc(3)
+		cdp := c.DataPoint()
+		So(cdp.Type, ShouldEqual, CounterType)
+		So(cdp.Value, ShouldEqual, 3)
+		val.Inc(2)
+		c.PostReportHook(cdp.Value)
+		So(val.Value(), ShouldEqual, 2)
+		So(c.DataPoint().Value, ShouldEqual, 2)
+	})
 }
@@ -2,8 +2,11 @@ package signalfx
 
 import (
 	"math"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"zvelo.io/go-signalfx/sfxproto"
 )
 
 // A CumulativeCounter represents a cumulative counter, that is a
@@ -78,77 +81,176 @@ func (cc *CumulativeCounter) PostReportHook(v int64) {
 	}
 }
 
+// CumulativeCounterOptions configure how a WrappedCumulativeCounter
+// reports the value it wraps, and handles its resets.
+type CumulativeCounterOptions struct {
+	// ResetDimension, if set, is a dimension added, with the value
+	// "true", to the first DataPoint reported after a reset.
+	ResetDimension string
+
+	// OnReset, if set, is called once whenever Reporter.Report detects
+	// a reset, with the value last reported before it and the value
+	// after it, e.g. in order to post an event.  It is called while
+	// the Reporter is locked, so it must not block nor call the
+	// Reporter.
+	OnReset func(previous, value int64)
+
+	// Delta makes the WrappedCumulativeCounter report, as a counter,
+	// the increase of the value since it was last reported rather than
+	// the value itself.  The value at the first report is taken as the
+	// starting point, and nothing is reported for it.
+	Delta bool
+}
+
 // A WrappedCumulativeCounter wraps a value elsewhere in memory.  That
-// value must monotonically increase.
+// value should monotonically increase; if it decreases, as it does
+// when its source restarts, the WrappedCumulativeCounter considers it
+// reset, and reports its new value immediately.
 type WrappedCumulativeCounter struct {
-	metric               string
-	dimensions           map[string]string
-	wrappedValue         Getter
-	value, previousValue uint64
+	metric        string
+	dimensions    map[string]string
+	wrappedValue  Getter
+	options       CumulativeCounterOptions
+	mu            sync.Mutex
+	previousValue uint64 // the value last successfully reported
+	reset         bool   // whether a reset is yet to be reported
+	started       bool   // whether the value has been reported yet
 }
 
 // WrapCumulativeCounter wraps a cumulative counter elsewhere in
-// memory, returning a newly-allocated WrappedCumulativeCounter.
-// Dimensions are neither copied nor modified; client code should take
-// care not to modify them in a goroutine-unsafe manner.
+// memory, returning a newly-allocated WrappedCumulativeCounter,
+// configured by the first of options, if any.  Dimensions are neither
+// copied nor modified; client code should take care not to modify
+// them in a goroutine-unsafe manner.
 func WrapCumulativeCounter(
 	metric string,
 	dimensions map[string]string,
 	value Getter,
+	options ...CumulativeCounterOptions,
 ) *WrappedCumulativeCounter {
-	return &WrappedCumulativeCounter{
+	ret := &WrappedCumulativeCounter{
 		metric:       metric,
 		dimensions:   dimensions,
 		wrappedValue: value,
 	}
+	if len(options) > 0 {
+		ret.options = options[0]
+	}
+	return ret
+}
+
+// WrapCumulativeCounterOf wraps a GetterOf in memory, as
+// WrapCumulativeCounter does, so that the type of its value is checked
+// at compile time.
+func WrapCumulativeCounterOf[T Number](
+	metric string,
+	dimensions map[string]string,
+	value GetterOf[T],
+	options ...CumulativeCounterOptions,
+) *WrappedCumulativeCounter {
+	return WrapCumulativeCounter(metric, dimensions, AsGetter(value), options...)
+}
+
+// reportInterval records any reset of the value, calling OnReset, and
+// takes the starting point of a Delta counter, so that DataPoint need
+// not change any state.
+func (cc *WrappedCumulativeCounter) reportInterval() {
+	value, err := getInt64(cc.wrappedValue)
+	if err != nil || value < 0 {
+		return
+	}
+
+	cc.mu.Lock()
+	previous := cc.previousValue
+	detected := !cc.reset && uint64(value) < previous
+	if detected {
+		cc.reset = true
+	}
+	if !cc.started && cc.options.Delta {
+		cc.previousValue = uint64(value)
+	}
+	cc.started = true
+	cc.mu.Unlock()
+
+	if detected && cc.options.OnReset != nil {
+		cc.options.OnReset(int64(previous), value)
+	}
 }
 
 // DataPoint returns a DataPoint reflecting the internal state of the
 // WrappedCumulativeCounter at a particular point in time.  It will
-// return nil of the counter's value or higher has been previously
-// reported.
+// return nil if the counter's value has been previously reported, or,
+// for a Delta counter, if it has never been reported.  If the value is
+// lower than that previously reported, it is considered reset, and
+// reported as is.
 func (cc *WrappedCumulativeCounter) DataPoint() *DataPoint {
-	previous := atomic.LoadUint64(&cc.previousValue)
-	gottenValue, err := cc.wrappedValue.Get()
+	value, err := getInt64(cc.wrappedValue)
 	if err != nil {
 		return nil
 	}
-	value, err := toInt64(gottenValue)
-	if err != nil {
+	if value < 0 {
 		return nil
 	}
-	if value < 0 {
+
+	cc.mu.Lock()
+	previous := cc.previousValue
+	reset := cc.reset || uint64(value) < previous
+	started := cc.started
+	cc.mu.Unlock()
+
+	if !reset && uint64(value) == previous {
 		return nil
 	}
-	if uint64(value) == previous {
+	if cc.options.Delta && !started {
 		return nil
 	}
-	return &DataPoint{
+
+	dp := &DataPoint{
 		Metric:     cc.metric,
 		Timestamp:  time.Now(),
 		Type:       CumulativeCounterType,
 		Dimensions: cc.dimensions,
 		Value:      value,
 	}
+	if cc.options.Delta {
+		dp.Type = CounterType
+		if !reset {
+			dp.Value = value - int64(previous)
+		}
+	}
+	if reset && cc.options.ResetDimension != "" {
+		dp.Dimensions = sfxproto.Dimensions(cc.dimensions).Append(sfxproto.Dimensions{
+			cc.options.ResetDimension: "true",
+		})
+	}
+	return dp
 }
 
 // PostReportHook records that a particular value has been
 // successfully reported.  If that value is negative, it will panic,
 // as cumulative counter values may never be negative.  It is
 // goroutine-safe.
+//
+// In the normal case, PostReportHook should only be called by
+// Reporter.Report.  Its argument must always be the value of a
+// DataPoint previously returned by DataPoint.
 func (cc *WrappedCumulativeCounter) PostReportHook(v int64) {
 	if v < 0 {
 		panic("negative cumulative counter should be impossible")
 	}
 	vv := uint64(v)
-	prev := atomic.LoadUint64(&cc.previousValue)
-	if vv <= prev {
-		return
-	}
-	for !atomic.CompareAndSwapUint64(&cc.previousValue, prev, vv) {
-		prev = atomic.LoadUint64(&cc.previousValue)
-		if vv <= prev {
-			return
-		}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	switch {
+	case cc.reset:
+		// after a reset, both the value and its delta are counted from 0
+		cc.previousValue = vv
+		cc.reset = false
+	case cc.options.Delta:
+		cc.previousValue += vv
+	case vv > cc.previousValue:
+		cc.previousValue = vv
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
 )
 
 func TestCumulativeCounter(t *testing.T) {
@@ -81,4 +82,143 @@ func TestCumulativeCounter(t *testing.T) {
 		cc.PostReportHook(17)
 		So(cc.previousValue, ShouldEqual, 17)
 	})
+	Convey("Wrapped cumulative counters detect resets", t, func() {
+		val := NewInt64(100)
+		var resets [][2]int64
+		cc := WrapCumulativeCounter("cumulative", map[string]string{"a": "1"}, val, CumulativeCounterOptions{
+			ResetDimension: "reset",
+			OnReset: func(previous, value int64) {
+				resets = append(resets, [2]int64{previous, value})
+			},
+		})
+
+		cc.reportInterval()
+		ccdp := cc.DataPoint()
+		So(ccdp.Type, ShouldEqual, CumulativeCounterType)
+		So(ccdp.Value, ShouldEqual, 100)
+		So(ccdp.Dimensions, ShouldResemble, map[string]string{"a": "1"})
+		cc.PostReportHook(ccdp.Value)
+		So(cc.DataPoint(), ShouldBeNil)
+
+		// the value drops, and is reported immediately, marked as a
+		// reset; DataPoint has no side effects, so the reset is only
+		// recorded when reported
+		val.Set(5)
+		ccdp = cc.DataPoint()
+		So(ccdp, ShouldNotBeNil)
+		So(ccdp.Value, ShouldEqual, 5)
+		So(ccdp.Dimensions, ShouldResemble, map[string]string{"a": "1", "reset": "true"})
+		So(resets, ShouldBeEmpty)
+		So(cc.reset, ShouldBeFalse)
+
+		cc.reportInterval()
+		So(cc.DataPoint().Dimensions, ShouldResemble, map[string]string{"a": "1", "reset": "true"})
+		So(resets, ShouldResemble, [][2]int64{{100, 5}})
+
+		// until it has been reported, it is still a reset, even if the
+		// value has passed the previous one
+		val.Set(120)
+		cc.reportInterval()
+		ccdp = cc.DataPoint()
+		So(ccdp.Value, ShouldEqual, 120)
+		So(ccdp.Dimensions["reset"], ShouldEqual, "true")
+		So(len(resets), ShouldEqual, 1)
+
+		cc.PostReportHook(ccdp.Value)
+		So(cc.previousValue, ShouldEqual, 120)
+		So(cc.DataPoint(), ShouldBeNil)
+		val.Set(130)
+		ccdp = cc.DataPoint()
+		So(ccdp.Value, ShouldEqual, 130)
+		So(ccdp.Dimensions, ShouldResemble, map[string]string{"a": "1"})
+
+		val.Set(0)
+		cc.reportInterval()
+		So(cc.DataPoint().Value, ShouldEqual, 0)
+		So(resets, ShouldResemble, [][2]int64{{100, 5}, {120, 0}})
+	})
+	Convey("Wrapped cumulative counters may report deltas", t, func() {
+		val := NewInt64(10)
+		cc := WrapCumulativeCounter("cumulative", nil, val, CumulativeCounterOptions{Delta: true})
+
+		// the first value reported is only the starting point
+		So(cc.DataPoint(), ShouldBeNil)
+		cc.reportInterval()
+		So(cc.DataPoint(), ShouldBeNil)
+
+		val.Set(25)
+		ccdp := cc.DataPoint()
+		So(ccdp.Type, ShouldEqual, CounterType)
+		So(ccdp.Value, ShouldEqual, 15)
+		So(ccdp.Dimensions, ShouldBeNil)
+
+		// an unreported delta is reported again
+		val.Set(27)
+		So(cc.DataPoint().Value, ShouldEqual, 17)
+		cc.PostReportHook(17)
+		So(cc.previousValue, ShouldEqual, 27)
+
+		// after a reset, the whole new value is the delta
+		val.Set(4)
+		So(cc.DataPoint().Value, ShouldEqual, 4)
+		cc.reportInterval()
+		val.Set(6)
+		ccdp = cc.DataPoint()
+		So(ccdp.Value, ShouldEqual, 6)
+		cc.PostReportHook(ccdp.Value)
+		val.Set(9)
+		So(cc.DataPoint().Value, ShouldEqual, 3)
+
+		Convey("through a reporter", func() {
+			config := NewConfig()
+			config.RoundTripper = okRoundTripper(nil)
+			reporter := NewReporter(config, nil)
+			reporter.Track(cc)
+
+			dps, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(len(dps), ShouldEqual, 1)
+			So(dps[0].Value, ShouldEqual, 3)
+
+			val.Set(2)
+			dps, err = reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(dps[0].Value, ShouldEqual, 2)
+
+			dps, err = reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(len(dps), ShouldEqual, 0)
+		})
+
+		Convey("which start from the first report", func() {
+			config := NewConfig()
+			config.RoundTripper = okRoundTripper(nil)
+			reporter := NewReporter(config, nil)
+			val := NewInt64(50)
+			var resets int
+			reporter.Track(WrapCumulativeCounter("cumulative", nil, val, CumulativeCounterOptions{
+				Delta:   true,
+				OnReset: func(int64, int64) { resets++ },
+			}))
+
+			So(reporter.Snapshot(false), ShouldBeEmpty)
+			dps, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(len(dps), ShouldEqual, 0)
+
+			val.Set(60)
+			dps, err = reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(dps[0].Value, ShouldEqual, 10)
+
+			// snapshots see a reset, but only reports record it
+			val.Set(1)
+			So(reporter.Snapshot(false)[0].Value, ShouldEqual, 1)
+			So(resets, ShouldEqual, 0)
+			dps, err = reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(dps[0].Value, ShouldEqual, 1)
+			So(resets, ShouldEqual, 1)
+		})
+	})
 }
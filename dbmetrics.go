@@ -0,0 +1,69 @@
+package signalfx
+
+import (
+	"database/sql"
+
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+// DBMetrics gathers and reports connection pool stats of a *sql.DB
+// for the reporter
+type DBMetrics struct {
+	metrics  []Metric
+	reporter *Reporter
+}
+
+// NewDBMetrics registers the reporter to report the connection pool
+// stats of db.  The name is reported as the "db" dimension, in
+// addition to dims, in order to differentiate multiple pools.
+func NewDBMetrics(reporter *Reporter, name string, db *sql.DB, dims map[string]string) *DBMetrics {
+	dims = sfxproto.Dimensions(dims).Append(map[string]string{"db": name})
+	stats := sql.DBStats{}
+	waitDuration := int64(0)
+	ret := &DBMetrics{
+		reporter: reporter,
+	}
+
+	ret.metrics = []Metric{
+		WrapGauge(
+			"sql-db-max-open-connections",
+			dims,
+			Value(&stats.MaxOpenConnections),
+		),
+		WrapGauge("sql-db-open-connections", dims, Value(&stats.OpenConnections)),
+		WrapGauge("sql-db-in-use-connections", dims, Value(&stats.InUse)),
+		WrapGauge("sql-db-idle-connections", dims, Value(&stats.Idle)),
+		WrapCumulativeCounter("sql-db-wait-count", dims, Value(&stats.WaitCount)),
+		WrapCumulativeCounter("sql-db-wait-duration-ns", dims, Value(&waitDuration)),
+		WrapCumulativeCounter(
+			"sql-db-max-idle-closed",
+			dims,
+			Value(&stats.MaxIdleClosed),
+		),
+		WrapCumulativeCounter(
+			"sql-db-max-idle-time-closed",
+			dims,
+			Value(&stats.MaxIdleTimeClosed),
+		),
+		WrapCumulativeCounter(
+			"sql-db-max-lifetime-closed",
+			dims,
+			Value(&stats.MaxLifetimeClosed),
+		),
+	}
+	reporter.Track(ret.metrics...)
+
+	reporter.AddPreReportCallback(func() {
+		stats = db.Stats()
+		waitDuration = stats.WaitDuration.Nanoseconds()
+	})
+
+	return ret
+}
+
+// Close the metric source and will stop reporting these pool stats to
+// the reporter. Implements the io.Closer interface.
+func (d *DBMetrics) Close() error {
+	d.reporter.Untrack(d.metrics...)
+	return nil
+}
@@ -0,0 +1,81 @@
+package signalfx
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+// nopDriver provides connections which can be opened, but not used
+type nopDriver struct{}
+
+func (nopDriver) Open(string) (driver.Conn, error) { return nopConn{}, nil }
+
+type nopConn struct{}
+
+func (nopConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (nopConn) Close() error                        { return nil }
+func (nopConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func init() {
+	sql.Register("signalfx-nop", nopDriver{})
+}
+
+func TestDBMetrics(t *testing.T) {
+	const forceFail = false
+
+	Convey("Testing DBMetrics", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`"OK"`))
+		}))
+		defer ts.Close()
+
+		config := NewConfig()
+		config.URL = ts.URL
+
+		reporter := NewReporter(config, nil)
+		So(reporter, ShouldNotBeNil)
+
+		db, err := sql.Open("signalfx-nop", "")
+		So(err, ShouldBeNil)
+		defer db.Close()
+		db.SetMaxOpenConns(3)
+
+		conn, err := db.Conn(context.Background())
+		So(err, ShouldBeNil)
+		defer conn.Close()
+
+		dbmetrics := NewDBMetrics(reporter, "test-db", db, map[string]string{"system": "test"})
+		datapoints, err := reporter.Report(context.Background())
+		So(err, ShouldBeNil)
+		// cumulative counters which are still zero are not reported
+		So(len(datapoints), ShouldEqual, 4)
+
+		for _, dp := range datapoints {
+			So(dp.Type, ShouldEqual, GaugeType)
+			So(dp.Dimensions, ShouldResemble, map[string]string{"system": "test", "db": "test-db"})
+
+			switch dp.Metric {
+			case "sql-db-max-open-connections":
+				So(dp.Value, ShouldEqual, 3)
+			case "sql-db-open-connections", "sql-db-in-use-connections":
+				So(dp.Value, ShouldEqual, 1)
+			case "sql-db-idle-connections":
+				So(dp.Value, ShouldEqual, 0)
+			default:
+				So(dp.Metric, ShouldEqual, forceFail)
+			}
+		}
+
+		So(dbmetrics.Close(), ShouldBeNil)
+		datapoints, err = reporter.Report(context.Background())
+		So(err, ShouldBeNil)
+		So(datapoints, ShouldBeEmpty)
+	})
+}
@@ -58,5 +58,14 @@ goroutine safe and can also be safely changed if via atomic methods.
 	counter := reporter.NewCounter("SomeOtherCounter", val, nil)
 	val.Inc(1)
 	atomic.AddInt64((*int64)(val), 1)
+
+GetterOf is the type-safe counterpart of Getter, for any Number type, so that
+illegal types are caught at compile time and values are not boxed. FuncGetter
+wraps a function, and Atomic is the goroutine safe counterpart of Int64 for any
+Number type. Both also satisfy Getter, and AsGetter adapts any other GetterOf.
+
+	val := signalfx.NewAtomic(0.0)
+	reporter.Track(signalfx.WrapGaugeOf[float64]("SomeFloatGauge", nil, val))
+	val.Inc(0.5)
 */
 package signalfx
@@ -0,0 +1,130 @@
+package signalfx
+
+import (
+	"sync"
+)
+
+// Protobuf wire tags of the fields of a DataPointUploadMessage, as
+// defined by sfxproto/signalfx.proto: the field number shifted left by
+// three, or'd with the wire type (0 for varints, 2 for length-delimited
+// fields).  The encoding matches that of proto.Marshal of the
+// protoDataPoint of each DataPoint, so, like it, MetricType (field 5)
+// is not sent: sending it would change the type of existing series,
+// which needs its own migration.
+const (
+	tagUploadDataPoints = 1<<3 | 2
+	tagMetric           = 2<<3 | 2
+	tagTimestamp        = 3<<3 | 0
+	tagValue            = 4<<3 | 2
+	tagDimensions       = 6<<3 | 2
+	tagDimensionKey     = 1<<3 | 2
+	tagDimensionValue   = 2<<3 | 2
+	tagIntValue         = 3<<3 | 0
+)
+
+// encoderPool holds the buffers into which Reporter.Report encodes its
+// payloads
+var encoderPool = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+// sizeVarint returns the encoded size of v
+func sizeVarint(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// appendVarint appends the encoding of v to b
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// sizeBytes returns the encoded size of a length-delimited field of n
+// bytes, including its single-byte tag
+func sizeBytes(n int) int {
+	return 1 + sizeVarint(uint64(n)) + n
+}
+
+// sizeDimension returns the encoded size of a Dimension message
+func sizeDimension(key, value string) int {
+	return sizeBytes(len(key)) + sizeBytes(len(value))
+}
+
+// appendDimension appends a Dimension, as a field of a DataPoint, to b
+func appendDimension(b []byte, key, value string) []byte {
+	b = append(b, tagDimensions)
+	b = appendVarint(b, uint64(sizeDimension(key, value)))
+	b = append(b, tagDimensionKey)
+	b = appendVarint(b, uint64(len(key)))
+	b = append(b, key...)
+	b = append(b, tagDimensionValue)
+	b = appendVarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// encodeDimensions returns dims encoded as the Dimension fields of a
+// DataPoint, so that they may be copied as-is into every DataPoint
+func encodeDimensions(dims map[string]string) []byte {
+	var ret []byte
+	for k, v := range dims {
+		ret = appendDimension(ret, k, v)
+	}
+	return ret
+}
+
+// sizeDataPoint returns the encoded size of the DataPoint message for dp
+func sizeDataPoint(prefix string, dims []byte, dp *DataPoint, timestamp int64) int {
+	n := sizeBytes(len(prefix) + len(dp.Metric))
+	n += 1 + sizeVarint(uint64(timestamp))
+	n += sizeBytes(1 + sizeVarint(uint64(dp.Value)))
+	n += len(dims)
+	for k, v := range dp.Dimensions {
+		n += sizeBytes(sizeDimension(k, v))
+	}
+	return n
+}
+
+// appendDataPoints appends the DataPointUploadMessage of dps to b,
+// exactly as proto.Marshal would encode the sfxproto.DataPoints built
+// from protoDataPoint, without any intermediate allocations.  dims are
+// the pre-encoded default dimensions.  DataPoints without a metric name
+// are skipped, as sfxproto.DataPoints.Add does.
+func appendDataPoints(b []byte, prefix string, dims []byte, dps []DataPoint) []byte {
+	for i := range dps {
+		dp := &dps[i]
+		if len(prefix)+len(dp.Metric) == 0 {
+			continue
+		}
+		timestamp := dp.Timestamp.UnixNano() / 1000000
+
+		b = append(b, tagUploadDataPoints)
+		b = appendVarint(b, uint64(sizeDataPoint(prefix, dims, dp, timestamp)))
+
+		b = append(b, tagMetric)
+		b = appendVarint(b, uint64(len(prefix)+len(dp.Metric)))
+		b = append(b, prefix...)
+		b = append(b, dp.Metric...)
+
+		b = append(b, tagTimestamp)
+		b = appendVarint(b, uint64(timestamp))
+
+		b = append(b, tagValue)
+		b = appendVarint(b, uint64(1+sizeVarint(uint64(dp.Value))))
+		b = append(b, tagIntValue)
+		b = appendVarint(b, uint64(dp.Value))
+
+		b = append(b, dims...)
+		for k, v := range dp.Dimensions {
+			b = appendDimension(b, k, v)
+		}
+	}
+	return b
+}
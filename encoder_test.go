@@ -0,0 +1,216 @@
+package signalfx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+// protoDataPoints builds dps the way Reporter.Report used to, before
+// encoding them with proto.Marshal
+func protoDataPoints(prefix string, defaultDims map[string]string, dps []DataPoint) *sfxproto.DataPoints {
+	dimensions := make([]*sfxproto.Dimension, 0, len(defaultDims))
+	for k, v := range defaultDims {
+		dimensions = append(dimensions, &sfxproto.Dimension{Key: proto.String(k), Value: proto.String(v)})
+	}
+
+	pdps := sfxproto.NewDataPoints(len(dps))
+	for _, dp := range dps {
+		pdps.Add(dp.protoDataPoint(prefix, dimensions))
+	}
+	return pdps
+}
+
+// sortDimensions sorts the dimensions of each datapoint, which are encoded
+// in map order
+func sortDimensions(pdps []*sfxproto.DataPoint) {
+	for _, pdp := range pdps {
+		sort.Slice(pdp.Dimensions, func(i, j int) bool {
+			if pdp.Dimensions[i].GetKey() != pdp.Dimensions[j].GetKey() {
+				return pdp.Dimensions[i].GetKey() < pdp.Dimensions[j].GetKey()
+			}
+			return pdp.Dimensions[i].GetValue() < pdp.Dimensions[j].GetValue()
+		})
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	Convey("Testing the datapoint encoder", t, func() {
+		now := time.Date(2016, time.March, 1, 12, 0, 0, 123456789, time.UTC)
+		dps := []DataPoint{
+			{Metric: "gauge", Type: GaugeType, Value: 5, Timestamp: now},
+			{Metric: "counter", Type: CounterType, Value: 1 << 40, Timestamp: now,
+				Dimensions: map[string]string{"a": "1"}},
+			{Metric: "negative", Type: GaugeType, Value: -3, Timestamp: time.Unix(-1, 0)},
+			{Metric: "cumulative", Type: CumulativeCounterType, Value: math.MaxInt64, Timestamp: now,
+				Dimensions: map[string]string{"b": strings.Repeat("v", 300)}},
+			{Metric: strings.Repeat("m", 200), Type: GaugeType, Value: math.MinInt64, Timestamp: now},
+			{Metric: "", Type: GaugeType, Value: 1, Timestamp: now},
+		}
+
+		Convey("its output should match proto.Marshal", func() {
+			for _, prefix := range []string{"", "prefix."} {
+				for _, defaultDims := range []map[string]string{nil, {"host": "h-1"}} {
+					expected, err := protoDataPoints(prefix, defaultDims, dps).Marshal()
+					So(err, ShouldBeNil)
+
+					got := appendDataPoints(nil, prefix, encodeDimensions(defaultDims), dps)
+					So(bytes.Equal(got, expected), ShouldBeTrue)
+				}
+			}
+		})
+
+		Convey("it should be decoded by proto.Unmarshal", func() {
+			defaultDims := map[string]string{"host": "h-1", "service": "s", "dc": "x"}
+			for i := range dps {
+				dps[i].Dimensions = map[string]string{"a": "1", "b": "2", "c": fmt.Sprint(i)}
+			}
+
+			expected := protoDataPoints("p.", defaultDims, dps).List()
+			sortDimensions(expected)
+
+			var msg sfxproto.DataPointUploadMessage
+			So(proto.Unmarshal(appendDataPoints(nil, "p.", encodeDimensions(defaultDims), dps), &msg), ShouldBeNil)
+			sortDimensions(msg.Datapoints)
+
+			So(len(msg.Datapoints), ShouldEqual, len(expected))
+			for i := range expected {
+				So(proto.Equal(msg.Datapoints[i], expected[i]), ShouldBeTrue)
+			}
+		})
+
+		Convey("a Reporter should submit encoded payloads", func() {
+			var bodies [][]byte
+			config := NewConfig()
+			config.RoundTripper = okRoundTripper(func(body []byte) { bodies = append(bodies, body) })
+
+			reporter := NewReporter(config, map[string]string{"host": "h-1"})
+			reporter.SetPrefix("p.")
+			reporter.Track(NewGauge("g", map[string]string{"a": "1"}, 5))
+
+			_, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			reporter.SetDimension("host", "h-2")
+			_, err = reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+
+			So(len(bodies), ShouldEqual, 2)
+			for i, host := range []string{"h-1", "h-2"} {
+				var msg sfxproto.DataPointUploadMessage
+				So(proto.Unmarshal(bodies[i], &msg), ShouldBeNil)
+				So(len(msg.Datapoints), ShouldEqual, 1)
+				So(msg.Datapoints[0].GetMetric(), ShouldEqual, "p.g")
+				So(msg.Datapoints[0].GetValue().GetIntValue(), ShouldEqual, 5)
+				So(sfxproto.NewDimensions(msg.Datapoints[0].Dimensions), ShouldResemble,
+					sfxproto.Dimensions{"host": host, "a": "1"})
+			}
+
+			reporter = NewReporter(config, nil)
+			reporter.AddDataPointsCallback(func() []DataPoint {
+				return []DataPoint{{Type: GaugeType, Value: 1, Timestamp: time.Now()}}
+			})
+			_, err = reporter.Report(context.Background())
+			So(err, ShouldResemble, ErrMarshal(sfxproto.ErrMarshalNoData))
+			So(len(bodies), ShouldEqual, 2)
+		})
+	})
+}
+
+// okRoundTripper answers every request with "OK", passing its body to f
+type okRoundTripper func(body []byte)
+
+func (f okRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if f != nil {
+		f(body)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(`"OK"`)),
+		Request:    req,
+	}, nil
+}
+
+// benchmarkDataPoints returns n datapoints with two dimensions each
+func benchmarkDataPoints(n int) []DataPoint {
+	ret := make([]DataPoint, n)
+	now := time.Now()
+	for i := range ret {
+		ret[i] = DataPoint{
+			Metric:     fmt.Sprintf("metric-%d", i%100),
+			Type:       GaugeType,
+			Value:      int64(i),
+			Timestamp:  now,
+			Dimensions: map[string]string{"shard": fmt.Sprint(i % 16), "kind": "benchmark"},
+		}
+	}
+	return ret
+}
+
+var benchmarkDimensions = map[string]string{
+	"host":    "host-1",
+	"service": "benchmark",
+	"region":  "us-east-1",
+	"env":     "production",
+}
+
+func BenchmarkEncodeDataPoints(b *testing.B) {
+	dps := benchmarkDataPoints(50000)
+	dims := encodeDimensions(benchmarkDimensions)
+	var buf []byte
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = appendDataPoints(buf[:0], "prefix.", dims, dps)
+	}
+	b.SetBytes(int64(len(buf)))
+}
+
+func BenchmarkMarshalDataPoints(b *testing.B) {
+	dps := benchmarkDataPoints(50000)
+	var n int
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err := protoDataPoints("prefix.", benchmarkDimensions, dps).Marshal()
+		if err != nil {
+			b.Fatal(err)
+		}
+		n = len(data)
+	}
+	b.SetBytes(int64(n))
+}
+
+func BenchmarkReport(b *testing.B) {
+	config := NewConfig()
+	config.RoundTripper = okRoundTripper(nil)
+	reporter := NewReporter(config, benchmarkDimensions)
+	for _, dp := range benchmarkDataPoints(5000) {
+		reporter.Track(NewGauge(dp.Metric, dp.Dimensions, dp.Value))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := reporter.Report(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package signalfx
+
+import (
+	"encoding/json"
+	"expvar"
+	"math"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+var (
+	// DefaultExpvarExclude are the expvar variables which are not
+	// reported by default: cmdline is not numeric, and memstats is
+	// better reported by GoMetrics.
+	DefaultExpvarExclude = []string{"cmdline", "memstats"}
+
+	// DefaultExpvarCumulative are the patterns of metric names
+	// which are reported as cumulative counters by default; all
+	// others are reported as gauges.
+	DefaultExpvarCumulative = []string{"*_total", "*-total", "*_count", "*-count", "*Total", "*Count"}
+)
+
+// DefaultExpvarMapDimension is the dimension key under which the keys
+// of an *expvar.Map are reported by default
+const DefaultExpvarMapDimension = "key"
+
+// An ExpvarCollector reports the variables published through the
+// expvar package.  *expvar.Int and *expvar.Float variables are
+// reported as-is (floats are rounded); the entries of an *expvar.Map
+// are reported under the map's name, with the entry's key as a
+// dimension (nested maps extend the metric name with their key
+// instead).  The JSON output of any other variable, such as an
+// expvar.Func, is parsed: numbers are reported, and objects are
+// flattened into dot-separated metric names.
+//
+// All operations on an ExpvarCollector are goroutine safe.
+type ExpvarCollector struct {
+	dimensions   map[string]string
+	include      []string
+	exclude      []string
+	cumulative   []string
+	mapDimension string
+	closed       bool
+	mu           sync.Mutex
+}
+
+// NewExpvarCollector returns a new ExpvarCollector and adds it to the
+// reporter as a DataPointCallback.  dims are added to every datapoint,
+// and are copied.
+func NewExpvarCollector(reporter *Reporter, dims map[string]string) *ExpvarCollector {
+	ret := &ExpvarCollector{
+		dimensions:   sfxproto.Dimensions(dims).Clone(),
+		exclude:      DefaultExpvarExclude,
+		cumulative:   DefaultExpvarCumulative,
+		mapDimension: DefaultExpvarMapDimension,
+	}
+	reporter.AddDataPointsCallback(ret.DataPoints)
+	return ret
+}
+
+// SetInclude sets the patterns, as matched by path.Match, of the
+// expvar variables to report.  If no patterns are set (the default),
+// all variables are reported.
+func (c *ExpvarCollector) SetInclude(patterns ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.include = patterns
+}
+
+// SetExclude sets the patterns, as matched by path.Match, of the
+// expvar variables not to report.  It defaults to
+// DefaultExpvarExclude.
+func (c *ExpvarCollector) SetExclude(patterns ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.exclude = patterns
+}
+
+// SetCumulative sets the patterns, as matched by path.Match, of the
+// metric names which are reported as cumulative counters.  It defaults
+// to DefaultExpvarCumulative.
+func (c *ExpvarCollector) SetCumulative(patterns ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cumulative = patterns
+}
+
+// SetMapDimension sets the dimension key under which the keys of an
+// *expvar.Map are reported.
+func (c *ExpvarCollector) SetMapDimension(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.mapDimension = key
+}
+
+// Close stops the ExpvarCollector from reporting.  Its DataPoints
+// remains registered with the Reporter, since a DataPointCallback
+// cannot be removed, but returns nothing once closed.  Implements the
+// io.Closer interface.
+func (c *ExpvarCollector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	return nil
+}
+
+// matchAny returns whether name matches any of patterns, as matched
+// by path.Match
+func matchAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DataPoints returns a DataPoint for each numeric value currently
+// published through expvar.  It is normally only called by
+// Reporter.Report.
+func (c *ExpvarCollector) DataPoints() []DataPoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	var ret []DataPoint
+	timestamp := time.Now()
+
+	add := func(metric string, dims map[string]string, value int64) {
+		t := GaugeType
+		if matchAny(metric, c.cumulative) {
+			t = CumulativeCounterType
+		}
+		ret = append(ret, DataPoint{
+			Metric:     metric,
+			Type:       t,
+			Value:      value,
+			Timestamp:  timestamp,
+			Dimensions: dims,
+		})
+	}
+
+	var walk func(name string, v expvar.Var)
+	walk = func(name string, v expvar.Var) {
+		if value, ok := expvarValue(v); ok {
+			add(name, c.dimensions, value)
+			return
+		}
+
+		switch tv := v.(type) {
+		case nil:
+		case *expvar.Map:
+			tv.Do(func(kv expvar.KeyValue) {
+				if value, ok := expvarValue(kv.Value); ok {
+					add(name, sfxproto.Dimensions(c.dimensions).Append(map[string]string{
+						c.mapDimension: kv.Key,
+					}), value)
+					return
+				}
+				walk(name+"."+kv.Key, kv.Value)
+			})
+		default:
+			var parsed interface{}
+			d := json.NewDecoder(strings.NewReader(v.String()))
+			d.UseNumber()
+			if err := d.Decode(&parsed); err != nil {
+				return
+			}
+			walkJSON(name, parsed, func(metric string, value int64) {
+				add(metric, c.dimensions, value)
+			})
+		}
+	}
+
+	expvar.Do(func(kv expvar.KeyValue) {
+		if len(c.include) > 0 && !matchAny(kv.Key, c.include) {
+			return
+		}
+		if matchAny(kv.Key, c.exclude) {
+			return
+		}
+		walk(kv.Key, kv.Value)
+	})
+
+	return ret
+}
+
+// expvarValue returns the value of an *expvar.Int or *expvar.Float
+func expvarValue(v expvar.Var) (int64, bool) {
+	switch tv := v.(type) {
+	case *expvar.Int:
+		return tv.Value(), true
+	case *expvar.Float:
+		return int64(math.Round(tv.Value())), true
+	}
+	return 0, false
+}
+
+// walkJSON calls f for each number within a decoded JSON value,
+// flattening objects into dot-separated names.  Arrays, strings, bools
+// and nulls are ignored.
+func walkJSON(name string, v interface{}, f func(string, int64)) {
+	switch tv := v.(type) {
+	case json.Number:
+		if i, err := tv.Int64(); err == nil {
+			f(name, i)
+		} else if fl, err := tv.Float64(); err == nil && fl >= math.MinInt64 && fl < math.MaxInt64 {
+			f(name, int64(math.Round(fl)))
+		}
+	case map[string]interface{}:
+		for k, v := range tv {
+			walkJSON(name+"."+k, v, f)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package signalfx
+
+import (
+	"encoding/json"
+	"expvar"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestExpvarCollector(t *testing.T) {
+	Convey("Testing ExpvarCollector", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`"OK"`))
+		}))
+		defer ts.Close()
+
+		config := NewConfig()
+		config.URL = ts.URL
+
+		reporter := NewReporter(config, nil)
+		So(reporter, ShouldNotBeNil)
+
+		// expvar variables can only be published once per process
+		if expvar.Get("sfxtest_requests_total") == nil {
+			expvar.NewInt("sfxtest_requests_total").Set(42)
+			expvar.NewFloat("sfxtest_load").Set(1.6)
+			m := expvar.NewMap("sfxtest_status")
+			m.Add("200", 7)
+			m.Add("500", 1)
+			name := new(expvar.String)
+			name.Set("x")
+			m.Set("name", name)
+			inner := new(expvar.Map).Init()
+			inner.Add("hit", 3)
+			m.Set("cache", inner)
+			expvar.Publish("sfxtest_func", expvar.Func(func() interface{} {
+				return map[string]interface{}{
+					"queue": map[string]interface{}{"depth": 5, "name": "q"},
+					"ratio": 2.4,
+					"list":  []int{1, 2},
+				}
+			}))
+			expvar.NewInt("sfxtest_excluded").Set(1)
+		}
+
+		c := NewExpvarCollector(reporter, map[string]string{"system": "test"})
+		c.SetInclude("sfxtest_*")
+		c.SetExclude("sfxtest_excluded")
+
+		datapoints, err := reporter.Report(context.Background())
+		So(err, ShouldBeNil)
+
+		type result struct {
+			t MetricType
+			v int64
+		}
+		got := map[string]result{}
+		for _, dp := range datapoints {
+			So(dp.Dimensions["system"], ShouldEqual, "test")
+			name := dp.Metric
+			if key, ok := dp.Dimensions["key"]; ok {
+				name += "{" + key + "}"
+			}
+			got[name] = result{dp.Type, dp.Value}
+		}
+
+		So(got, ShouldResemble, map[string]result{
+			"sfxtest_requests_total":    {CumulativeCounterType, 42},
+			"sfxtest_load":              {GaugeType, 2},
+			"sfxtest_status{200}":       {GaugeType, 7},
+			"sfxtest_status{500}":       {GaugeType, 1},
+			"sfxtest_status.cache{hit}": {GaugeType, 3},
+			"sfxtest_func.queue.depth":  {GaugeType, 5},
+			"sfxtest_func.ratio":        {GaugeType, 2},
+		})
+
+		Convey("numbers beyond an int64 should be skipped", func() {
+			got := map[string]int64{}
+			for _, n := range []string{"9223372036854775808", "-9223372036854775809", "9.2e18", "-1.5"} {
+				walkJSON(n, json.Number(n), func(name string, v int64) { got[name] = v })
+			}
+			So(got, ShouldResemble, map[string]int64{
+				"-9223372036854775809": math.MinInt64,
+				"9.2e18":               9200000000000000000,
+				"-1.5":                 -2,
+			})
+		})
+
+		Convey("naming rules should be configurable", func() {
+			c.SetCumulative("sfxtest_status*")
+			c.SetMapDimension("code")
+			dps := c.DataPoints()
+			found := false
+			for _, dp := range dps {
+				if dp.Metric == "sfxtest_status" && dp.Dimensions["code"] == "200" {
+					found = true
+					So(dp.Type, ShouldEqual, CumulativeCounterType)
+				}
+				if dp.Metric == "sfxtest_requests_total" {
+					So(dp.Type, ShouldEqual, GaugeType)
+				}
+			}
+			So(found, ShouldBeTrue)
+		})
+
+		Convey("a closed collector should not report", func() {
+			So(c.Close(), ShouldBeNil)
+			So(c.DataPoints(), ShouldBeEmpty)
+		})
+	})
+}
@@ -2,6 +2,7 @@ package signalfx
 
 import (
 	"math"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -62,14 +63,20 @@ func WrapGauge(
 	}
 }
 
+// WrapGaugeOf wraps a GetterOf in memory, as WrapGauge does, so that
+// the type of its value is checked at compile time.
+func WrapGaugeOf[T Number](
+	metric string,
+	dimensions map[string]string,
+	value GetterOf[T],
+) *WrappedGauge {
+	return WrapGauge(metric, dimensions, AsGetter(value))
+}
+
 // DataPoint returns a DataPoint reflecting the current value of the
 // WrappedGauge.
 func (c *WrappedGauge) DataPoint() *DataPoint {
-	gottenValue, err := c.value.Get()
-	if err != nil {
-		return nil
-	}
-	value, err := toInt64(gottenValue)
+	value, err := getInt64(c.value)
 	if err != nil {
 		return nil
 	}
@@ -82,16 +89,77 @@ func (c *WrappedGauge) DataPoint() *DataPoint {
 	}
 }
 
+// stability is the policy of StableGauge and StableWrappedGauge: a
+// value is not reported again while it is unchanged since it was last
+// successfully reported, unless a heartbeat is due.
+type stability struct {
+	mu        sync.Mutex
+	reported  bool  // whether any value was successfully reported
+	prevValue int64 // the value last successfully reported
+	silent    int   // the number of intervals since it was reported
+	heartbeat int
+}
+
+// SetHeartbeat makes an unchanged value be reported anyway every n
+// reporting intervals, so that SignalFx does not consider the series
+// stale.  Only the intervals of Reporter.Report are counted, not other
+// calls to DataPoint.  If n is zero (the default), an unchanged value
+// is never reported again.
+func (s *stability) SetHeartbeat(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.heartbeat = n
+}
+
+// reportInterval counts the reporting intervals of Reporter.Report, so
+// that other calls to DataPoint, e.g. by Reporter.Snapshot, do not
+// advance the heartbeat
+func (s *stability) reportInterval() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.silent++
+}
+
+// suppress returns whether dp should not be reported
+func (s *stability) suppress(dp *DataPoint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.reported || dp.Value != s.prevValue {
+		return false
+	}
+	return s.heartbeat <= 0 || s.silent < s.heartbeat
+}
+
+// PostReportHook remembers the successfully-reported value, so that it
+// is not reported again while unchanged.
+//
+// In the normal case, PostReportHook should only be called by
+// Reporter.Report.  Its argument must always be the value of a
+// DataPoint previously returned by DataPoint.
+func (s *stability) PostReportHook(v int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reported = true
+	s.prevValue = v
+	s.silent = 0
+}
+
 // A StableGauge is the same as a Guage, but will not report the same value
-// multiple times sequentially.
+// multiple times sequentially, unless a heartbeat is set.  It only
+// remembers a value once it has been successfully reported, so a value
+// whose report fails is reported again.
 type StableGauge struct {
-	gauge     *Gauge
-	prevValue int64
+	gauge *Gauge
+	stability
 }
 
 // NewStableGauge returns a new StableGauge with the indicated initial state.
 func NewStableGauge(metric string, dimensions map[string]string, value int64) *StableGauge {
-	return &StableGauge{gauge: NewGauge(metric, dimensions, value), prevValue: math.MaxInt64}
+	return &StableGauge{gauge: NewGauge(metric, dimensions, value)}
 }
 
 // Record sets a gauge's internal state to the indicated value.
@@ -100,13 +168,150 @@ func (g *StableGauge) Record(value int64) {
 }
 
 // DataPoint returns a DataPoint reflecting the StableGauge's internal state
-// at the current point in time, if it differs since the last call.
+// at the current point in time, unless it is the same as that last
+// reported and no heartbeat is due.
 func (g *StableGauge) DataPoint() *DataPoint {
 	dp := g.gauge.DataPoint()
-	if dp.Value == atomic.LoadInt64(&g.prevValue) {
-		// Value is the same, don't report it
+	if g.suppress(dp) {
 		return nil
 	}
-	atomic.StoreInt64(&g.prevValue, dp.Value)
 	return dp
 }
+
+// A StableWrappedGauge is the same as a WrappedGauge, but does not
+// report the same value multiple times sequentially, as a StableGauge.
+type StableWrappedGauge struct {
+	gauge *WrappedGauge
+	stability
+}
+
+// WrapStableGauge wraps a value in memory, as WrapGauge does.
+func WrapStableGauge(
+	metric string,
+	dimensions map[string]string,
+	value Getter,
+) *StableWrappedGauge {
+	return &StableWrappedGauge{gauge: WrapGauge(metric, dimensions, value)}
+}
+
+// DataPoint returns a DataPoint reflecting the current value of the
+// wrapped gauge, unless it is the same as that last reported and no
+// heartbeat is due.
+func (g *StableWrappedGauge) DataPoint() *DataPoint {
+	dp := g.gauge.DataPoint()
+	if dp == nil || g.suppress(dp) {
+		return nil
+	}
+	return dp
+}
+
+// peakGauge tracks the extreme value recorded since it was last
+// reported, for MaxGauge and MinGauge.  Record only updates value, with
+// setIf, so that it never blocks; DataPoint moves value into pending,
+// where it stays until PostReportHook.  Thus a peak recorded while
+// reporting, or before a report which fails, is reported next time.
+type peakGauge struct {
+	metric     string
+	dimensions map[string]string
+	value      int64
+	pending    int64 // guarded by mu
+	unset      int64
+	setIf      func(addr *int64, val int64)
+	mu         sync.Mutex
+}
+
+func newPeakGauge(metric string, dimensions map[string]string, unset int64, setIf func(*int64, int64)) peakGauge {
+	return peakGauge{
+		metric:     metric,
+		dimensions: dimensions,
+		value:      unset,
+		pending:    unset,
+		unset:      unset,
+		setIf:      setIf,
+	}
+}
+
+// Record records value, keeping it only if it is a new peak.
+func (g *peakGauge) Record(value int64) {
+	g.setIf(&g.value, value)
+}
+
+// DataPoint returns a DataPoint reflecting the peak value recorded
+// since the last report, or nil if none has been recorded.  It does not
+// reset that peak, leaving that to PostReportHook.
+func (g *peakGauge) DataPoint() *DataPoint {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if value := atomic.SwapInt64(&g.value, g.unset); value != g.unset {
+		g.setIf(&g.pending, value)
+	}
+	return g.dataPoint(g.pending)
+}
+
+// snapshot returns the DataPoint which DataPoint would, without moving
+// value into pending.
+func (g *peakGauge) snapshot() *DataPoint {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	peak := g.pending
+	if value := atomic.LoadInt64(&g.value); value != g.unset {
+		g.setIf(&peak, value)
+	}
+	return g.dataPoint(peak)
+}
+
+func (g *peakGauge) dataPoint(peak int64) *DataPoint {
+	if peak == g.unset {
+		return nil
+	}
+	return &DataPoint{
+		Metric:     g.metric,
+		Timestamp:  time.Now(),
+		Type:       GaugeType,
+		Dimensions: g.dimensions,
+		Value:      peak,
+	}
+}
+
+// PostReportHook resets the reported peak.  Values recorded since
+// DataPoint was called are kept for the next report.  Its argument is
+// ignored.
+//
+// In the normal case, PostReportHook should only be called by
+// Reporter.Report.
+func (g *peakGauge) PostReportHook(int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pending = g.unset
+}
+
+// A MaxGauge is a gauge reporting the highest value recorded since it
+// was last successfully reported, so that spikes between reports are
+// not missed.  If no value has been recorded, it is not reported.  It
+// neither copies nor modifies its dimensions.  All operations on
+// MaxGauges are goroutine safe.
+type MaxGauge struct {
+	peakGauge
+}
+
+// NewMaxGauge returns a new MaxGauge, with no value recorded.
+func NewMaxGauge(metric string, dimensions map[string]string) *MaxGauge {
+	return &MaxGauge{newPeakGauge(metric, dimensions, math.MinInt64, setIfMax)}
+}
+
+// A MinGauge is a gauge reporting the lowest value recorded since it
+// was last successfully reported, so that dips between reports are not
+// missed.  If no value has been recorded, it is not reported.  It
+// neither copies nor modifies its dimensions.  All operations on
+// MinGauges are goroutine safe.
+type MinGauge struct {
+	peakGauge
+}
+
+// NewMinGauge returns a new MinGauge, with no value recorded.
+func NewMinGauge(metric string, dimensions map[string]string) *MinGauge {
+	return &MinGauge{newPeakGauge(metric, dimensions, math.MaxInt64, setIfMin)}
+}
@@ -2,12 +2,22 @@ package signalfx
 
 import (
 	"fmt"
+	"net/http"
+	"sync"
 	"testing"
 	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
 )
 
+// errRoundTripper fails every request
+type errRoundTripper struct{}
+
+func (errRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("unreachable")
+}
+
 func TestGauge(t *testing.T) {
 	Convey("Gauge works as specified", t, func() {
 		g := NewGauge("gauge", nil, 0)
@@ -51,6 +61,7 @@ func TestGauge(t *testing.T) {
 		So(gdp.Metric, ShouldEqual, "stable-gauge")
 		So(gdp.Dimensions, ShouldBeNil)
 		So(gdp.Value, ShouldEqual, 12)
+		g.PostReportHook(gdp.Value)
 		So(g.prevValue, ShouldEqual, 12)
 		t := gdp.Timestamp
 
@@ -67,9 +78,15 @@ func TestGauge(t *testing.T) {
 		So(gdp.Metric, ShouldEqual, "stable-gauge")
 		So(gdp.Dimensions, ShouldBeNil)
 		So(gdp.Value, ShouldEqual, 8)
-		So(g.prevValue, ShouldEqual, 8)
 		So(t.Before(gdp.Timestamp), ShouldBeTrue)
 
+		// until it has been reported, it is not remembered
+		So(g.prevValue, ShouldEqual, 12)
+		So(g.DataPoint(), ShouldNotBeNil)
+		g.PostReportHook(8)
+		So(g.prevValue, ShouldEqual, 8)
+		So(g.DataPoint(), ShouldBeNil)
+
 		Convey("reports a 0 if it is the first report", func() {
 			g := NewStableGauge("stable-gauge", nil, 0)
 			So(g, ShouldNotBeNil)
@@ -83,8 +100,117 @@ func TestGauge(t *testing.T) {
 			So(gdp.Metric, ShouldEqual, "stable-gauge")
 			So(gdp.Dimensions, ShouldBeNil)
 			So(gdp.Value, ShouldEqual, 0)
+			g.PostReportHook(gdp.Value)
 			So(g.prevValue, ShouldEqual, 0)
 		})
+
+		Convey("reports unchanged values on every heartbeat", func() {
+			g.SetHeartbeat(3)
+			g.PostReportHook(8)
+			for i := 0; i < 2; i++ {
+				g.reportInterval()
+				So(g.DataPoint(), ShouldBeNil)
+			}
+
+			// only reporting intervals count
+			So(g.DataPoint(), ShouldBeNil)
+			g.reportInterval()
+			gdp := g.DataPoint()
+			So(gdp, ShouldNotBeNil)
+			So(gdp.Value, ShouldEqual, 8)
+
+			// the heartbeat is due until it has been reported
+			So(g.DataPoint(), ShouldNotBeNil)
+			g.PostReportHook(gdp.Value)
+			So(g.DataPoint(), ShouldBeNil)
+
+			Convey("of Reporter.Report, not Reporter.Snapshot", func() {
+				config := NewConfig()
+				config.RoundTripper = okRoundTripper(nil)
+				reporter := NewReporter(config, nil)
+				reporter.Track(g)
+
+				for i := 0; i < 2; i++ {
+					for j := 0; j < 5; j++ {
+						So(len(reporter.Snapshot(false)), ShouldEqual, 0)
+					}
+					dps, err := reporter.Report(context.Background())
+					So(err, ShouldBeNil)
+					So(len(dps), ShouldEqual, 0)
+				}
+				dps, err := reporter.Report(context.Background())
+				So(err, ShouldBeNil)
+				So(len(dps), ShouldEqual, 1)
+				So(dps[0].Value, ShouldEqual, 8)
+			})
+		})
+
+		Convey("does not lose changes when a report fails", func() {
+			config := NewConfig()
+			config.RoundTripper = errRoundTripper{}
+			reporter := NewReporter(config, nil)
+			reporter.Track(g)
+
+			g.Record(9)
+			_, err := reporter.Report(context.Background())
+			So(err, ShouldNotBeNil)
+
+			config.RoundTripper = okRoundTripper(nil)
+			reporter = NewReporter(config, nil)
+			reporter.Track(g)
+			dps, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(len(dps), ShouldEqual, 1)
+			So(dps[0].Value, ShouldEqual, 9)
+
+			dps, err = reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(len(dps), ShouldEqual, 0)
+		})
+	})
+	Convey("Generic wrapped gauges work as specified", t, func() {
+		val := NewAtomic(2.6)
+		g := WrapGaugeOf[float64]("float-gauge", nil, val)
+		gdp := g.DataPoint()
+		So(gdp.Metric, ShouldEqual, "float-gauge")
+		So(gdp.Type, ShouldEqual, GaugeType)
+		So(gdp.Value, ShouldEqual, 3)
+
+		g = WrapGaugeOf("func-gauge", nil, FuncGetter[uint16](func() (uint16, error) {
+			return 12, nil
+		}))
+		So(g.DataPoint().Value, ShouldEqual, 12)
+
+		// generic Getters may be wrapped as any other Getter
+		So(WrapGauge("float-gauge", nil, val).DataPoint().Value, ShouldEqual, 3)
+		val.Set(-1)
+		cc := WrapCumulativeCounterOf[float64]("float-counter", nil, val)
+		So(cc.DataPoint(), ShouldBeNil)
+	})
+	Convey("StableWrappedGauge works as specified", t, func() {
+		i := NewInt64(5)
+		g := WrapStableGauge("stable-wrapped-gauge", nil, i)
+		gdp := g.DataPoint()
+		So(gdp, ShouldNotBeNil)
+		So(gdp.Metric, ShouldEqual, "stable-wrapped-gauge")
+		So(gdp.Type, ShouldEqual, GaugeType)
+		So(gdp.Value, ShouldEqual, 5)
+		g.PostReportHook(gdp.Value)
+		So(g.DataPoint(), ShouldBeNil)
+
+		i.Set(6)
+		So(g.DataPoint().Value, ShouldEqual, 6)
+
+		g.SetHeartbeat(1)
+		g.PostReportHook(6)
+		So(g.DataPoint(), ShouldBeNil)
+		g.reportInterval()
+		So(g.DataPoint().Value, ShouldEqual, 6)
+
+		g = WrapStableGauge("broken", nil, GetterFunc(func() (interface{}, error) {
+			return 0, fmt.Errorf("this is an error")
+		}))
+		So(g.DataPoint(), ShouldBeNil)
 	})
 	Convey("Broken wrapped gauges break cleanly", t, func() {
 		g := WrapGauge("broken", nil, GetterFunc(func() (interface{}, error) {
@@ -98,4 +224,76 @@ func TestGauge(t *testing.T) {
 		gdp = g.DataPoint()
 		So(gdp, ShouldBeNil)
 	})
+	Convey("MaxGauge and MinGauge work as specified", t, func() {
+		max := NewMaxGauge("max-gauge", map[string]string{"a": "1"})
+		min := NewMinGauge("min-gauge", nil)
+		So(max.DataPoint(), ShouldBeNil)
+		So(min.DataPoint(), ShouldBeNil)
+
+		var wg sync.WaitGroup
+		for i := int64(0); i < 8; i++ {
+			wg.Add(1)
+			go func(i int64) {
+				defer wg.Done()
+				for j := int64(-100); j <= 100; j++ {
+					max.Record(i * j)
+					min.Record(i * j)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		gdp := max.DataPoint()
+		So(gdp, ShouldNotBeNil)
+		So(gdp.Metric, ShouldEqual, "max-gauge")
+		So(gdp.Type, ShouldEqual, GaugeType)
+		So(gdp.Dimensions, ShouldResemble, map[string]string{"a": "1"})
+		So(gdp.Value, ShouldEqual, 700)
+		So(min.DataPoint().Value, ShouldEqual, -700)
+
+		// peaks recorded before PostReportHook are kept; lower ones
+		// are reported next time
+		max.Record(800)
+		max.Record(5)
+		So(max.DataPoint().Value, ShouldEqual, 800)
+		max.Record(6)
+		max.PostReportHook(800)
+		So(max.DataPoint().Value, ShouldEqual, 6)
+		max.PostReportHook(6)
+		So(max.DataPoint(), ShouldBeNil)
+
+		min.PostReportHook(-700)
+		So(min.DataPoint(), ShouldBeNil)
+		min.Record(3)
+		min.Record(4)
+		So(min.DataPoint().Value, ShouldEqual, 3)
+
+		// snapshots set nothing aside
+		max.Record(9)
+		So(max.snapshot().Value, ShouldEqual, 9)
+		So(max.value, ShouldEqual, 9)
+		So(max.pending, ShouldEqual, max.unset)
+
+		Convey("peaks are not lost when a report fails", func() {
+			config := NewConfig()
+			config.RoundTripper = errRoundTripper{}
+			reporter := NewReporter(config, nil)
+			max := NewMaxGauge("max-gauge", nil)
+			reporter.Track(max)
+
+			max.Record(10)
+			_, err := reporter.Report(context.Background())
+			So(err, ShouldNotBeNil)
+
+			max.Record(2)
+			config.RoundTripper = okRoundTripper(nil)
+			reporter = NewReporter(config, nil)
+			reporter.Track(max)
+			dps, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(dps[0].Value, ShouldEqual, 10)
+
+			So(max.DataPoint(), ShouldBeNil)
+		})
+	})
 }
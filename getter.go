@@ -1,6 +1,9 @@
 package signalfx
 
-import "sync/atomic"
+import (
+	"math"
+	"sync/atomic"
+)
 
 // Getter is an interface that is used by DataPoint. Get must return
 // any kind of int, float, nil or pointer to those types (a nil
@@ -195,3 +198,175 @@ func (v *Uint64) Value() uint64 {
 func (v *Uint64) Subtract(delta int64) {
 	atomic.AddUint64((*uint64)(v), uint64(-delta))
 }
+
+/************************* Generic *************************/
+
+// Number is the constraint satisfied by the types of values which may
+// be reported: any kind of int or float.  Floats are rounded to the
+// closest int64.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// GetterOf is the type-safe counterpart of Getter: Load returns a
+// value of type T, so that an illegal type is caught at compile time,
+// and that the value need not be boxed in an interface{}.
+type GetterOf[T Number] interface {
+	Load() (T, error)
+}
+
+// isFloat returns whether T is a kind of float
+func isFloat[T Number]() bool {
+	half := 0.5
+	return T(half) != 0
+}
+
+// numberToInt64 converts val to an int64, rounding it if it is a float,
+// and saturating it if it is beyond the range of an int64
+func numberToInt64[T Number](val T) int64 {
+	var zero T
+	switch {
+	case isFloat[T]():
+		return saturate(float64(val))
+	case zero-1 > 0 && uint64(val) > math.MaxInt64:
+		return math.MaxInt64
+	}
+	return int64(val)
+}
+
+// getterOf adapts a GetterOf to the Getter interface
+type getterOf[T Number] struct {
+	g GetterOf[T]
+}
+
+func (g getterOf[T]) Get() (interface{}, error) {
+	return g.g.Load()
+}
+
+func (g getterOf[T]) getInt64() (int64, error) {
+	val, err := g.g.Load()
+	return numberToInt64(val), err
+}
+
+// AsGetter adapts g to the Getter interface, so that it may be wrapped
+// by any of the wrapped metrics.  They get its value without boxing it.
+func AsGetter[T Number](g GetterOf[T]) Getter {
+	return getterOf[T]{g}
+}
+
+// SubtractorOf is the type-safe counterpart of Subtractor.
+type SubtractorOf[T Number] interface {
+	GetterOf[T]
+	Subtract(int64)
+}
+
+// subtractorOf adapts a SubtractorOf to the Subtractor interface
+type subtractorOf[T Number] struct {
+	getterOf[T]
+	s SubtractorOf[T]
+}
+
+func (s subtractorOf[T]) Subtract(delta int64) {
+	s.s.Subtract(delta)
+}
+
+// AsSubtractor adapts s to the Subtractor interface, as AsGetter does
+// to the Getter interface.
+func AsSubtractor[T Number](s SubtractorOf[T]) Subtractor {
+	return subtractorOf[T]{getterOf[T]{s}, s}
+}
+
+// The FuncGetter type is an adapter to allow the use of ordinary
+// functions as GetterOfs, as GetterFunc does for Getters.  It also
+// satisfies the Getter interface.
+type FuncGetter[T Number] func() (T, error)
+
+// Load calls f()
+func (f FuncGetter[T]) Load() (T, error) {
+	return f()
+}
+
+// Get satisfies the Getter interface
+func (f FuncGetter[T]) Get() (interface{}, error) {
+	return f()
+}
+
+func (f FuncGetter[T]) getInt64() (int64, error) {
+	val, err := f()
+	return numberToInt64(val), err
+}
+
+// Atomic is a value of any Number type which satisfies the GetterOf,
+// SubtractorOf, Getter and Subtractor interfaces using atomic
+// operations, as Int64 does for int64s.  Its zero value is 0.
+type Atomic[T Number] struct {
+	bits uint64
+}
+
+// NewAtomic returns a new Atomic set to val
+func NewAtomic[T Number](val T) *Atomic[T] {
+	ret := &Atomic[T]{}
+	ret.Set(val)
+	return ret
+}
+
+// toBits returns the representation of val in an Atomic; ints are
+// truncated back to T, so their representation need not be canonical
+func toBits[T Number](val T) uint64 {
+	if isFloat[T]() {
+		return math.Float64bits(float64(val))
+	}
+	return uint64(val)
+}
+
+func fromBits[T Number](bits uint64) T {
+	if isFloat[T]() {
+		return T(math.Float64frombits(bits))
+	}
+	return T(bits)
+}
+
+// Set the value using an atomic operation
+func (v *Atomic[T]) Set(val T) {
+	atomic.StoreUint64(&v.bits, toBits(val))
+}
+
+// Inc atomically adds delta to an Atomic
+func (v *Atomic[T]) Inc(delta T) T {
+	if !isFloat[T]() {
+		return T(atomic.AddUint64(&v.bits, uint64(delta)))
+	}
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		val := fromBits[T](old) + delta
+		if atomic.CompareAndSwapUint64(&v.bits, old, toBits(val)) {
+			return val
+		}
+	}
+}
+
+// Value atomically returns the value of an Atomic
+func (v *Atomic[T]) Value() T {
+	return fromBits[T](atomic.LoadUint64(&v.bits))
+}
+
+// Load satisfies the GetterOf interface
+func (v *Atomic[T]) Load() (T, error) {
+	return v.Value(), nil
+}
+
+// Get satisfies the Getter interface
+func (v *Atomic[T]) Get() (interface{}, error) {
+	return v.Value(), nil
+}
+
+func (v *Atomic[T]) getInt64() (int64, error) {
+	return numberToInt64(v.Value()), nil
+}
+
+// Subtract atomically subtracts delta from an Atomic
+func (v *Atomic[T]) Subtract(delta int64) {
+	v.Inc(T(-delta))
+}
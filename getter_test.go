@@ -1,6 +1,9 @@
 package signalfx
 
 import (
+	"fmt"
+	"math"
+	"sync"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -110,3 +113,94 @@ func TestGetter(t *testing.T) {
 		So(err, ShouldBeNil)
 	})
 }
+
+func TestGetterOf(t *testing.T) {
+	Convey("Testing GetterOf", t, func() {
+		/************************* Atomic **************************/
+
+		i8 := NewAtomic[int8](-3)
+		So(i8.Value(), ShouldEqual, -3)
+		So(i8.Inc(-126), ShouldEqual, 127) // overflows as int8 does
+		i8.Subtract(7)
+		So(i8.Value(), ShouldEqual, 120)
+
+		var u16 Atomic[uint16]
+		So(u16.Value(), ShouldEqual, 0)
+		u16.Set(math.MaxUint16)
+		So(u16.Inc(2), ShouldEqual, 1)
+
+		f64 := NewAtomic(1.25)
+		So(f64.Inc(1), ShouldEqual, 2.25)
+		v, err := f64.Load()
+		So(v, ShouldEqual, 2.25)
+		So(err, ShouldBeNil)
+		gv, err := f64.Get()
+		So(gv, ShouldEqual, 2.25)
+		So(err, ShouldBeNil)
+		f64.Subtract(3)
+		So(f64.Value(), ShouldEqual, -0.75)
+
+		f32 := NewAtomic[float32](0.5)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					f32.Inc(0.5)
+				}
+			}()
+		}
+		wg.Wait()
+		So(f32.Value(), ShouldEqual, 4000.5)
+
+		/*********************** FuncGetter ************************/
+
+		fg := FuncGetter[uint32](func() (uint32, error) {
+			return 42, nil
+		})
+		u, err := fg.Load()
+		So(u, ShouldEqual, 42)
+		So(err, ShouldBeNil)
+		gv, err = fg.Get()
+		So(gv, ShouldEqual, uint32(42))
+		So(err, ShouldBeNil)
+
+		/************************ AsGetter *************************/
+
+		g := AsGetter[float64](f64)
+		gv, err = g.Get()
+		So(gv, ShouldEqual, -0.75)
+		So(err, ShouldBeNil)
+
+		// floats are rounded, and errors passed through
+		i, err := getInt64(g)
+		So(i, ShouldEqual, -1)
+		So(err, ShouldBeNil)
+		i, err = getInt64(AsGetter(NewAtomic(math.Inf(1))))
+		So(i, ShouldEqual, math.MaxInt64)
+		So(err, ShouldBeNil)
+
+		// as are unsigned values beyond an int64
+		i, err = getInt64(AsGetter(NewAtomic[uint64](math.MaxUint64)))
+		So(i, ShouldEqual, math.MaxInt64)
+		So(err, ShouldBeNil)
+		i, err = getInt64(NewAtomic[uint64](math.MaxInt64))
+		So(i, ShouldEqual, math.MaxInt64)
+		So(err, ShouldBeNil)
+		i, err = getInt64(NewAtomic[int8](-8))
+		So(i, ShouldEqual, -8)
+		So(err, ShouldBeNil)
+		_, err = getInt64(FuncGetter[int](func() (int, error) {
+			return 0, fmt.Errorf("this is an error")
+		}))
+		So(err, ShouldNotBeNil)
+
+		// other Getters are still converted
+		i, err = getInt64(Value(uint8(7)))
+		So(i, ShouldEqual, 7)
+		So(err, ShouldBeNil)
+		_, err = getInt64(Value("7"))
+		So(err, ShouldEqual, ErrIllegalType)
+	})
+}
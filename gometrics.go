@@ -1,11 +1,77 @@
 package signalfx
 
 import (
+	"math"
 	"runtime"
+	"sort"
+	"sync"
 	"time"
 )
 
-// GoMetrics gathers and reports generally useful go system stats for the reporter
+// gcPauseBufferSize is the length of the MemStats.PauseNs and
+// MemStats.PauseEnd circular buffers
+const gcPauseBufferSize = uint32(len(runtime.MemStats{}.PauseNs))
+
+// gcPauses returns the durations of the GC pauses which completed
+// since lastNumGC and after lastPauseEnd, along with the total number
+// of GCs since lastNumGC.  If more GCs happened than fit into the
+// circular buffers, only the most recent are returned.
+func gcPauses(mstat *runtime.MemStats, lastNumGC uint32, lastPauseEnd uint64) (pauses []uint64, count uint32) {
+	count = mstat.NumGC - lastNumGC
+	first := lastNumGC
+	if count > gcPauseBufferSize {
+		first = mstat.NumGC - gcPauseBufferSize
+	}
+
+	for i := first; i != mstat.NumGC; i++ {
+		// the most recent pause is at PauseNs[(NumGC+255)%256]
+		j := i % gcPauseBufferSize
+		if mstat.PauseEnd[j] <= lastPauseEnd {
+			continue
+		}
+		pauses = append(pauses, mstat.PauseNs[j])
+	}
+
+	return pauses, count
+}
+
+// gcPauseStats is the aggregate of the GC pauses of a report interval
+type gcPauseStats struct {
+	mu       sync.Mutex
+	max, p99 int64
+	ok       bool
+}
+
+func (s *gcPauseStats) set(pauses []uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ok = len(pauses) > 0
+	if !s.ok {
+		return
+	}
+
+	sort.Slice(pauses, func(i, j int) bool { return pauses[i] < pauses[j] })
+	s.max = int64(pauses[len(pauses)-1])
+	s.p99 = int64(pauses[int(math.Ceil(0.99*float64(len(pauses))))-1])
+}
+
+func (s *gcPauseStats) getter(v *int64) Getter {
+	return GetterFunc(func() (interface{}, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if !s.ok {
+			return nil, ErrNotAvailable
+		}
+		return *v, nil
+	})
+}
+
+// GoMetrics gathers and reports generally useful go system stats for the reporter.
+// In addition to the MemStats fields, the GC pauses which happened since the
+// previous report are reported as go-metric-gc-pause-max-ns,
+// go-metric-gc-pause-p99-ns and go-metric-gc-pause-count.
 type GoMetrics struct {
 	metrics  []Metric
 	reporter *Reporter
@@ -16,6 +82,10 @@ type GoMetrics struct {
 func NewGoMetrics(reporter *Reporter, dims map[string]string) *GoMetrics {
 	start := time.Now()
 	mstat := runtime.MemStats{}
+	var lastNumGC uint32
+	var lastPauseEnd uint64
+	pauseStats := &gcPauseStats{}
+	pauseCount := NewCounter("go-metric-gc-pause-count", dims, 0)
 	ret := &GoMetrics{
 		reporter: reporter,
 	}
@@ -54,6 +124,9 @@ func NewGoMetrics(reporter *Reporter, dims map[string]string) *GoMetrics {
 			Value(&mstat.PauseTotalNs),
 		),
 		WrapGauge("go-metric-num-gc", dims, Value(&mstat.NumGC)),
+		WrapGauge("go-metric-gc-pause-max-ns", dims, pauseStats.getter(&pauseStats.max)),
+		WrapGauge("go-metric-gc-pause-p99-ns", dims, pauseStats.getter(&pauseStats.p99)),
+		pauseCount,
 
 		WrapGauge(
 			"go-metric-gomaxprocs",
@@ -95,6 +168,14 @@ func NewGoMetrics(reporter *Reporter, dims map[string]string) *GoMetrics {
 
 	reporter.AddPreReportCallback(func() {
 		runtime.ReadMemStats(&mstat)
+
+		pauses, count := gcPauses(&mstat, lastNumGC, lastPauseEnd)
+		pauseStats.set(pauses)
+		pauseCount.Inc(uint64(count))
+		lastNumGC = mstat.NumGC
+		if mstat.NumGC > 0 {
+			lastPauseEnd = mstat.PauseEnd[(mstat.NumGC+gcPauseBufferSize-1)%gcPauseBufferSize]
+		}
 	})
 
 	return ret
@@ -3,6 +3,7 @@ package signalfx
 import (
 	"net/http"
 	"net/http/httptest"
+	"runtime"
 	"testing"
 	"time"
 
@@ -69,8 +70,12 @@ func TestGoMetrics(t *testing.T) {
 				"go-metric-gomaxprocs",
 				"go-metric-uptime-ns",
 				"go-metric-num-cpu",
-				"go-metric-num-goroutine":
+				"go-metric-num-goroutine",
+				"go-metric-gc-pause-max-ns",
+				"go-metric-gc-pause-p99-ns":
 				testDataPoint(dp, GaugeType)
+			case "go-metric-gc-pause-count":
+				testDataPoint(dp, CounterType)
 			case "go-metric-total-alloc", "go-metric-lookups", "go-metric-mallocs", "go-metric-frees", "go-metric-pause-total-ns", "go-metric-num-cgo-call":
 				testDataPoint(dp, CumulativeCounterType)
 			default:
@@ -81,3 +86,66 @@ func TestGoMetrics(t *testing.T) {
 		So(gometrics.Close(), ShouldBeNil)
 	})
 }
+
+func TestGCPauses(t *testing.T) {
+	Convey("Testing gcPauses", t, func() {
+		mstat := runtime.MemStats{}
+		setGC := func(n uint32) {
+			// pause i lasts i ns and ends at i+1000
+			for i := mstat.NumGC; i < n; i++ {
+				mstat.PauseNs[i%gcPauseBufferSize] = uint64(i)
+				mstat.PauseEnd[i%gcPauseBufferSize] = uint64(i) + 1000
+			}
+			mstat.NumGC = n
+		}
+
+		pauses, count := gcPauses(&mstat, 0, 0)
+		So(pauses, ShouldBeEmpty)
+		So(count, ShouldEqual, 0)
+
+		setGC(3)
+		pauses, count = gcPauses(&mstat, 0, 0)
+		So(pauses, ShouldResemble, []uint64{0, 1, 2})
+		So(count, ShouldEqual, 3)
+
+		setGC(5)
+		pauses, count = gcPauses(&mstat, 3, 1002)
+		So(pauses, ShouldResemble, []uint64{3, 4})
+		So(count, ShouldEqual, 2)
+
+		Convey("the circular buffer should wrap around", func() {
+			setGC(260)
+			pauses, count = gcPauses(&mstat, 250, 1249)
+			So(pauses, ShouldResemble, []uint64{250, 251, 252, 253, 254, 255, 256, 257, 258, 259})
+			So(count, ShouldEqual, 10)
+		})
+
+		Convey("only the most recent pauses should be returned after an overflow", func() {
+			setGC(600)
+			pauses, count = gcPauses(&mstat, 5, 1004)
+			So(count, ShouldEqual, 595)
+			So(len(pauses), ShouldEqual, 256)
+			So(pauses[0], ShouldEqual, 344)
+			So(pauses[255], ShouldEqual, 599)
+		})
+
+		Convey("pause stats should be aggregated", func() {
+			s := &gcPauseStats{}
+			s.set(nil)
+			_, err := s.getter(&s.max).Get()
+			So(err, ShouldEqual, ErrNotAvailable)
+
+			pauses := make([]uint64, 200)
+			for i := range pauses {
+				pauses[i] = uint64(200 - i)
+			}
+			s.set(pauses)
+			max, err := s.getter(&s.max).Get()
+			So(err, ShouldBeNil)
+			So(max, ShouldEqual, 200)
+			p99, err := s.getter(&s.p99).Get()
+			So(err, ShouldBeNil)
+			So(p99, ShouldEqual, 198)
+		})
+	})
+}
@@ -0,0 +1,95 @@
+package signalfx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+// handlerDataPoint is the JSON representation of a DataPoint served by
+// Reporter.Handler
+type handlerDataPoint struct {
+	Metric     string            `json:"metric"`
+	Type       string            `json:"type"`
+	Value      int64             `json:"value"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+	Source     string            `json:"source"`
+}
+
+// formatDimensions returns dims as a sorted, comma-separated list of
+// key=value pairs
+func formatDimensions(dims map[string]string) string {
+	pairs := make([]string, 0, len(dims))
+	for k, v := range dims {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Handler returns an http.Handler which renders what the Reporter
+// would report at this point in time: every tracked metric, bucket,
+// window and pending one-shot DataPoint, with the metric prefix and
+// default dimensions applied.  It is intended for debugging.
+//
+// The snapshot is taken with Snapshot, so it does not affect what is
+// subsequently reported.  DataPointCallbacks are only called if
+// requested with ?callbacks=true, since calling them may have side
+// effects: a callback which resets its own state each time it is
+// called (such as sfxhttp.Transport.DataPoints) loses that state, and
+// a PromScraper scrapes its target.
+//
+// The snapshot is rendered as a table, or as JSON if requested with
+// ?format=json or an Accept header of application/json.
+func (r *Reporter) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sdps := r.snapshot(req.URL.Query().Get("callbacks") == "true")
+
+		dps := make([]handlerDataPoint, len(sdps))
+		for i, sdp := range sdps {
+			dps[i] = handlerDataPoint{
+				Metric:     sdp.Metric,
+				Type:       sfxproto.MetricType(sdp.Type).String(),
+				Value:      sdp.Value,
+				Timestamp:  sdp.Timestamp,
+				Dimensions: sdp.Dimensions,
+				Source:     sdp.Source,
+			}
+		}
+		sort.SliceStable(dps, func(i, j int) bool {
+			if dps[i].Metric != dps[j].Metric {
+				return dps[i].Metric < dps[j].Metric
+			}
+			return formatDimensions(dps[i].Dimensions) < formatDimensions(dps[j].Dimensions)
+		})
+
+		format := req.URL.Query().Get("format")
+		if format == "" && strings.Contains(req.Header.Get("Accept"), "application/json") {
+			format = "json"
+		}
+
+		if format == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(dps)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "METRIC\tTYPE\tVALUE\tSOURCE\tDIMENSIONS")
+		for _, dp := range dps {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n",
+				dp.Metric, dp.Type, dp.Value, dp.Source, formatDimensions(dp.Dimensions))
+		}
+		_ = tw.Flush()
+	})
+}
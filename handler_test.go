@@ -0,0 +1,125 @@
+package signalfx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestHandler(t *testing.T) {
+	Convey("Testing Reporter.Handler", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`"OK"`))
+		}))
+		defer ts.Close()
+
+		config := NewConfig()
+		config.URL = ts.URL
+
+		reporter := NewReporter(config, map[string]string{"host": "test"})
+		reporter.SetPrefix("prefix.")
+
+		bucket := reporter.NewBucket("bucket", nil)
+		bucket.Add(3)
+		bucket.Add(5)
+
+		counter := NewCounter("counter", map[string]string{"a": "1"}, 0)
+		counter.Inc(4)
+		reporter.Track(counter)
+
+		So(reporter.Record("one-shot", nil, 9), ShouldBeNil)
+
+		called := 0
+		reporter.AddDataPointsCallback(func() []DataPoint {
+			called++
+			return []DataPoint{{Metric: "callback", Type: GaugeType, Value: 1}}
+		})
+
+		get := func(url string, accept string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", url, nil)
+			if accept != "" {
+				req.Header.Set("Accept", accept)
+			}
+			w := httptest.NewRecorder()
+			reporter.Handler().ServeHTTP(w, req)
+			return w
+		}
+
+		Convey("it should render JSON", func() {
+			w := get("/?format=json&callbacks=true", "")
+			So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+
+			var dps []handlerDataPoint
+			So(json.Unmarshal(w.Body.Bytes(), &dps), ShouldBeNil)
+			// 5 from the bucket, the counter, the one-shot and
+			// the callback
+			So(len(dps), ShouldEqual, 8)
+			So(called, ShouldEqual, 1)
+
+			sources := map[string]int{}
+			for _, dp := range dps {
+				So(strings.HasPrefix(dp.Metric, "prefix."), ShouldBeTrue)
+				So(dp.Dimensions["host"], ShouldEqual, "test")
+				sources[dp.Source]++
+
+				if dp.Metric == "prefix.counter" {
+					So(dp.Type, ShouldEqual, "COUNTER")
+					So(dp.Value, ShouldEqual, 4)
+					So(dp.Dimensions["a"], ShouldEqual, "1")
+				}
+			}
+			So(sources, ShouldResemble, map[string]int{
+				"bucket":   5,
+				"metric":   1,
+				"one-shot": 1,
+				"callback": 1,
+			})
+
+			// the Accept header should work too, and callbacks
+			// should not be called unless requested
+			w = get("/", "application/json")
+			So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			So(json.Unmarshal(w.Body.Bytes(), &dps), ShouldBeNil)
+			So(len(dps), ShouldEqual, 7)
+			So(called, ShouldEqual, 1)
+		})
+
+		Convey("it should render a table", func() {
+			w := get("/", "")
+			So(called, ShouldEqual, 0)
+			body := w.Body.String()
+			So(body, ShouldStartWith, "METRIC")
+			So(body, ShouldContainSubstring, "prefix.counter")
+			So(body, ShouldContainSubstring, "a=1,host=test")
+			So(body, ShouldNotContainSubstring, "prefix.callback")
+		})
+
+		Convey("it should not affect what is reported", func() {
+			get("/", "")
+			get("/", "")
+
+			So(bucket.Count(), ShouldEqual, 2)
+
+			dps, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(len(dps), ShouldEqual, 8)
+			for _, dp := range dps {
+				switch dp.Metric {
+				case "counter":
+					So(dp.Value, ShouldEqual, 4)
+				case "one-shot":
+					So(dp.Value, ShouldEqual, 9)
+				}
+			}
+
+			// the snapshot should reflect the report
+			snapshot := reporter.Snapshot(false)
+			So(len(snapshot), ShouldEqual, 3)
+		})
+	})
+}
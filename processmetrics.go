@@ -0,0 +1,277 @@
+package signalfx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userHZ is the number of clock ticks per second in which /proc
+// reports CPU times.  It is 100 on every mainstream Linux platform.
+const userHZ = 100
+
+// ErrNotAvailable is returned by a Getter whose value could not be
+// read, e.g. because the underlying /proc file does not exist
+var ErrNotAvailable = errors.New("value not available")
+
+// procStats holds the values read from /proc on each report.  Each
+// group of values is only valid if the file it is read from could be
+// read and parsed.
+type procStats struct {
+	statValid                        bool
+	utime, stime, numThreads, vsize  int64
+	statusValid                      bool
+	vmRSS, volCtxSw, nonvolCtxSw     int64
+	ioValid                          bool
+	rchar, wchar, readBytes, wrBytes int64
+	limitsValid                      bool
+	maxFiles                         int64
+	fdValid                          bool
+	numFDs                           int64
+}
+
+// ProcessMetrics gathers and reports process-level OS stats, read from
+// /proc, for the reporter
+type ProcessMetrics struct {
+	metrics  []Metric
+	reporter *Reporter
+}
+
+// NewProcessMetrics registers the reporter to report process stats
+// from /proc/self.  You should provide enough dims to differentiate
+// this set of metrics.  On systems without /proc the metrics are
+// simply never reported.
+func NewProcessMetrics(reporter *Reporter, dims map[string]string) *ProcessMetrics {
+	return newProcessMetrics(reporter, dims, "/proc/self")
+}
+
+func newProcessMetrics(reporter *Reporter, dims map[string]string, dir string) *ProcessMetrics {
+	var mu sync.Mutex
+	stats := procStats{}
+	self := filepath.Clean(dir) == "/proc/self"
+	ret := &ProcessMetrics{
+		reporter: reporter,
+	}
+
+	value := func(valid *bool, v *int64) Getter {
+		return GetterFunc(func() (interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if !*valid {
+				return nil, ErrNotAvailable
+			}
+			return *v, nil
+		})
+	}
+
+	ret.metrics = []Metric{
+		WrapCumulativeCounter(
+			"process-cpu-user-ns",
+			dims,
+			value(&stats.statValid, &stats.utime),
+		),
+		WrapCumulativeCounter(
+			"process-cpu-system-ns",
+			dims,
+			value(&stats.statValid, &stats.stime),
+		),
+		WrapGauge("process-num-threads", dims, value(&stats.statValid, &stats.numThreads)),
+		WrapGauge("process-virtual-memory-bytes", dims, value(&stats.statValid, &stats.vsize)),
+		WrapGauge("process-resident-memory-bytes", dims, value(&stats.statusValid, &stats.vmRSS)),
+		WrapCumulativeCounter(
+			"process-voluntary-context-switches",
+			dims,
+			value(&stats.statusValid, &stats.volCtxSw),
+		),
+		WrapCumulativeCounter(
+			"process-nonvoluntary-context-switches",
+			dims,
+			value(&stats.statusValid, &stats.nonvolCtxSw),
+		),
+		WrapCumulativeCounter("process-io-read-chars", dims, value(&stats.ioValid, &stats.rchar)),
+		WrapCumulativeCounter("process-io-write-chars", dims, value(&stats.ioValid, &stats.wchar)),
+		WrapCumulativeCounter("process-io-read-bytes", dims, value(&stats.ioValid, &stats.readBytes)),
+		WrapCumulativeCounter("process-io-write-bytes", dims, value(&stats.ioValid, &stats.wrBytes)),
+		WrapGauge("process-max-fds", dims, value(&stats.limitsValid, &stats.maxFiles)),
+		WrapGauge("process-open-fds", dims, value(&stats.fdValid, &stats.numFDs)),
+	}
+	reporter.Track(ret.metrics...)
+
+	reporter.AddPreReportCallback(func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		stats.statValid = readProcStat(filepath.Join(dir, "stat"), &stats) == nil
+		stats.statusValid = readProcStatus(filepath.Join(dir, "status"), &stats) == nil
+		stats.ioValid = readProcIO(filepath.Join(dir, "io"), &stats) == nil
+		stats.limitsValid = readProcLimits(filepath.Join(dir, "limits"), &stats) == nil
+
+		if n, err := countFDs(filepath.Join(dir, "fd"), self); err == nil {
+			stats.numFDs = n
+			stats.fdValid = true
+		} else {
+			stats.fdValid = false
+		}
+	})
+
+	return ret
+}
+
+// Close the metric source and will stop reporting these process stats
+// to the reporter. Implements the io.Closer interface.
+func (p *ProcessMetrics) Close() error {
+	p.reporter.Untrack(p.metrics...)
+	return nil
+}
+
+var errProcFormat = errors.New("unexpected /proc file format")
+
+// readProcStat parses /proc/[pid]/stat; see proc(5)
+func readProcStat(name string, stats *procStats) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	// the command name may contain spaces and parentheses, so
+	// start parsing after the last closing parenthesis; fields[0]
+	// is then field 3 (state) of proc(5)
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return errProcFormat
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 21 {
+		return errProcFormat
+	}
+
+	var vals [4]int64
+	for j, field := range []int{14, 15, 20, 23} {
+		if vals[j], err = strconv.ParseInt(fields[field-3], 10, 64); err != nil {
+			return err
+		}
+	}
+
+	tick := int64(time.Second / userHZ)
+	stats.utime = vals[0] * tick
+	stats.stime = vals[1] * tick
+	stats.numThreads = vals[2]
+	stats.vsize = vals[3]
+
+	return nil
+}
+
+// countFDs returns the number of file descriptors listed in the named
+// directory, without stat'ing each of them.  If self is true, it is
+// that of the current process, so the descriptor with which it is read
+// is not counted.
+func countFDs(name string, self bool) (int64, error) {
+	dir, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+	n := int64(len(names))
+	if self && n > 0 {
+		n--
+	}
+	return n, nil
+}
+
+// readProcKeyValues calls f for each "key: value" line of the named
+// file
+func readProcKeyValues(name string, f func(key, value string) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if err := f(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// readProcStatus parses /proc/[pid]/status
+func readProcStatus(name string, stats *procStats) error {
+	return readProcKeyValues(name, func(key, value string) (err error) {
+		switch key {
+		case "VmRSS":
+			// reported in kB
+			stats.vmRSS, err = strconv.ParseInt(strings.TrimSuffix(value, " kB"), 10, 64)
+			stats.vmRSS *= 1024
+		case "voluntary_ctxt_switches":
+			stats.volCtxSw, err = strconv.ParseInt(value, 10, 64)
+		case "nonvoluntary_ctxt_switches":
+			stats.nonvolCtxSw, err = strconv.ParseInt(value, 10, 64)
+		}
+		return err
+	})
+}
+
+// readProcIO parses /proc/[pid]/io
+func readProcIO(name string, stats *procStats) error {
+	return readProcKeyValues(name, func(key, value string) (err error) {
+		switch key {
+		case "rchar":
+			stats.rchar, err = strconv.ParseInt(value, 10, 64)
+		case "wchar":
+			stats.wchar, err = strconv.ParseInt(value, 10, 64)
+		case "read_bytes":
+			stats.readBytes, err = strconv.ParseInt(value, 10, 64)
+		case "write_bytes":
+			stats.wrBytes, err = strconv.ParseInt(value, 10, 64)
+		}
+		return err
+	})
+}
+
+// readProcLimits parses the soft open file limit out of
+// /proc/[pid]/limits
+func readProcLimits(name string, stats *procStats) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	const prefix = "Max open files"
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		fields := strings.Fields(line[len(prefix):])
+		if len(fields) < 1 {
+			return errProcFormat
+		}
+		if fields[0] == "unlimited" {
+			// an unlimited limit is not reported
+			return errProcFormat
+		}
+		stats.maxFiles, err = strconv.ParseInt(fields[0], 10, 64)
+		return err
+	}
+
+	return errProcFormat
+}
@@ -0,0 +1,94 @@
+package signalfx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestProcessMetrics(t *testing.T) {
+	const forceFail = false
+
+	Convey("Testing ProcessMetrics", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`"OK"`))
+		}))
+		defer ts.Close()
+
+		config := NewConfig()
+		config.URL = ts.URL
+
+		reporter := NewReporter(config, nil)
+		So(reporter, ShouldNotBeNil)
+
+		Convey("fixture proc files should be parsed", func() {
+			pm := newProcessMetrics(reporter, map[string]string{"system": "test"}, "testdata/proc/self")
+			datapoints, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(len(datapoints), ShouldEqual, 13)
+
+			expected := map[string]struct {
+				t MetricType
+				v int64
+			}{
+				"process-cpu-user-ns":                   {CumulativeCounterType, 1500000000},
+				"process-cpu-system-ns":                 {CumulativeCounterType, 250000000},
+				"process-num-threads":                   {GaugeType, 7},
+				"process-virtual-memory-bytes":          {GaugeType, 1073741824},
+				"process-resident-memory-bytes":         {GaugeType, 8192 * 1024},
+				"process-voluntary-context-switches":    {CumulativeCounterType, 120},
+				"process-nonvoluntary-context-switches": {CumulativeCounterType, 8},
+				"process-io-read-chars":                 {CumulativeCounterType, 1000},
+				"process-io-write-chars":                {CumulativeCounterType, 2000},
+				"process-io-read-bytes":                 {CumulativeCounterType, 4096},
+				"process-io-write-bytes":                {CumulativeCounterType, 8192},
+				"process-max-fds":                       {GaugeType, 1024},
+				"process-open-fds":                      {GaugeType, 5},
+			}
+
+			for _, dp := range datapoints {
+				So(dp.Dimensions, ShouldResemble, map[string]string{"system": "test"})
+				e, ok := expected[dp.Metric]
+				if !ok {
+					So(dp.Metric, ShouldEqual, forceFail)
+				}
+				So(dp.Type, ShouldEqual, e.t)
+				So(dp.Value, ShouldEqual, e.v)
+			}
+
+			So(pm.Close(), ShouldBeNil)
+			datapoints, err = reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(datapoints, ShouldBeEmpty)
+		})
+
+		Convey("file descriptors should be counted", func() {
+			n, err := countFDs("testdata/proc/self/fd", false)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 5)
+
+			before, err := countFDs("/proc/self/fd", true)
+			if err != nil {
+				return // no /proc
+			}
+			f, err := os.Open("testdata/proc/self/stat")
+			So(err, ShouldBeNil)
+			defer f.Close()
+			after, err := countFDs("/proc/self/fd", true)
+			So(err, ShouldBeNil)
+			So(after, ShouldEqual, before+1)
+		})
+
+		Convey("missing proc files should not be reported", func() {
+			pm := newProcessMetrics(reporter, nil, "testdata/proc/missing")
+			datapoints, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(datapoints, ShouldBeEmpty)
+			So(pm.Close(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,282 @@
+package signalfx
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+// PrometheusContentType is the content type of the Prometheus text
+// exposition format
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// promSeries is a single Prometheus time series
+type promSeries struct {
+	name, labels, typ string
+	value             int64
+
+	// counter is whether value accumulates CounterType values
+	counter bool
+	// owner is the tracked Metric which reported the series, if any
+	owner Metric
+}
+
+// promBucket holds the totals of a Bucket's reported values
+type promBucket struct {
+	count, sum int64
+}
+
+// promState is what a Reporter remembers of previous reports in order
+// to expose Prometheus metrics.  Counters are reset by each report, so
+// their reported values are accumulated here; cumulative counters and
+// gauges of tracked metrics keep their last reported value, since they
+// may not be reported again unless they change.  Any other series is
+// dropped once absent from a report, as is every series of a metric
+// once it is untracked.  It is guarded by the Reporter's mutex.
+type promState struct {
+	series  map[string]*promSeries
+	buckets map[bucket]*promBucket
+}
+
+// promName sanitizes a metric name into a valid Prometheus metric
+// name
+func promName(name string) string {
+	ret := []rune(name)
+	for i, r := range ret {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || r == ':' ||
+			r >= '0' && r <= '9' && i > 0) {
+			ret[i] = '_'
+		}
+	}
+	if len(ret) == 0 {
+		return "_"
+	}
+	return string(ret)
+}
+
+// promLabelName sanitizes a dimension key into a valid Prometheus
+// label name
+func promLabelName(name string) string {
+	return strings.Replace(promName(name), ":", "_", -1)
+}
+
+var promLabelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels renders dims as a Prometheus label set, e.g.
+// {a="1",b="2"}
+func promLabels(dims map[string]string) string {
+	if len(dims) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(dims))
+	for k, v := range dims {
+		pairs = append(pairs, promLabelName(k)+`="`+promLabelValueReplacer.Replace(v)+`"`)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// promSeriesFor returns a new promSeries for dp, with the Reporter's
+// metric prefix and default dimensions applied
+func (r *Reporter) promSeriesFor(dp DataPoint) *promSeries {
+	typ := "gauge"
+	if dp.Type == CounterType || dp.Type == CumulativeCounterType {
+		typ = "counter"
+	}
+	return &promSeries{
+		name:    promName(r.metricPrefix + dp.Metric),
+		labels:  promLabels(sfxproto.Dimensions(r.defaultDimensions).Append(dp.Dimensions)),
+		typ:     typ,
+		value:   dp.Value,
+		counter: dp.Type == CounterType,
+	}
+}
+
+// observe adds dp to series, returning its key: a counter's value is
+// added to the total, any other value replaces the previous one
+func (r *Reporter) observe(series map[string]*promSeries, dp DataPoint) string {
+	s := r.promSeriesFor(dp)
+	key := s.name + s.labels
+	if prev, ok := series[key]; ok && dp.Type == CounterType {
+		prev.value += dp.Value
+		return key
+	}
+	series[key] = s
+	return key
+}
+
+// reported records the datapoints of a successful report: those of
+// callbacks and windows, one-shots, and tracked metrics, where
+// metricDPs[i] was reported by metrics[i]
+func (p *promState) reported(
+	r *Reporter,
+	callbackDPs, oneShotDPs, metricDPs []DataPoint,
+	metrics []Metric,
+	bucketDPs map[bucket][]DataPoint,
+) {
+	seen := map[string]bool{}
+	for _, dp := range callbackDPs {
+		seen[r.observe(p.series, dp)] = true
+	}
+	for _, dp := range oneShotDPs {
+		seen[r.observe(p.series, dp)] = true
+	}
+	for i, dp := range metricDPs {
+		key := r.observe(p.series, dp)
+		seen[key] = true
+		p.series[key].owner = metrics[i]
+	}
+	for key, s := range p.series {
+		if s.owner != nil {
+			if _, ok := r.metrics[s.owner]; ok {
+				continue
+			}
+		} else if s.counter || seen[key] {
+			continue
+		}
+		delete(p.series, key)
+	}
+
+	for b, dps := range bucketDPs {
+		pb, ok := p.buckets[b]
+		if !ok {
+			pb = &promBucket{}
+			p.buckets[b] = pb
+		}
+		for _, dp := range dps {
+			metric, ok := b.metricOf(dp)
+			if !ok {
+				continue
+			}
+			switch metric {
+			case BucketMetricCount:
+				pb.count += dp.Value
+			case BucketMetricSum:
+				pb.sum += dp.Value
+			}
+		}
+	}
+}
+
+// promFamilies gathers the current Prometheus series, without
+// affecting what will be reported to SignalFx
+func (r *Reporter) promFamilies() map[string][]*promSeries {
+	r.lock()
+	defer r.unlock()
+
+	series := make(map[string]*promSeries, len(r.prom.series))
+	for k, s := range r.prom.series {
+		cp := *s
+		series[k] = &cp
+	}
+
+	for _, dp := range r.oneShots {
+		r.observe(series, dp)
+	}
+	for metric := range r.metrics {
+		if dp := snapshotOf(metric); dp != nil {
+			r.observe(series, *dp)
+		}
+	}
+
+	// forget buckets which are no longer tracked
+	for b := range r.prom.buckets {
+		if _, ok := r.buckets[b]; !ok {
+			delete(r.prom.buckets, b)
+		}
+	}
+	for b := range r.buckets {
+		total := promBucket{count: int64(b.Count()), sum: b.Sum()}
+		if pb, ok := r.prom.buckets[b]; ok {
+			total.count += pb.count
+			total.sum += pb.sum
+		}
+
+		name := promName(r.metricPrefix + b.Metric())
+		labels := promLabels(sfxproto.Dimensions(r.defaultDimensions).Append(b.Dimensions()))
+		add := func(name, typ string, value int64) {
+			series[name+labels] = &promSeries{name: name, labels: labels, typ: typ, value: value}
+		}
+		add(name+"_count", "summary", total.count)
+		add(name+"_sum", "summary", total.sum)
+		if b.Count() > 0 {
+			add(name+"_min", "gauge", b.Min())
+			add(name+"_max", "gauge", b.Max())
+		}
+	}
+
+	families := map[string][]*promSeries{}
+	for _, s := range series {
+		family := s.name
+		if s.typ == "summary" {
+			family = strings.TrimSuffix(strings.TrimSuffix(s.name, "_count"), "_sum")
+		}
+		families[family] = append(families[family], s)
+	}
+	return families
+}
+
+// PrometheusHandler returns an http.Handler which serves the
+// Reporter's metrics in the Prometheus text exposition format, so that
+// they may be scraped at the same time as they are reported to
+// SignalFx.  Reading them does not affect what is reported.
+//
+// Metric names and dimension keys are sanitized, and dimensions become
+// labels.  Counters are exposed as Prometheus counters, accumulating
+// their successfully reported values; cumulative counters are exposed
+// as counters and gauges as gauges, each keeping the last value seen.
+// A Bucket is exposed as a summary (its _count and _sum) along with
+// _min and _max gauges of its current values.  DataPointCallbacks are
+// not called, since a callback may reset its own state each time it
+// is called; their datapoints are exposed as of the last successful
+// report, so that those no longer reported disappear.  The series of
+// untracked metrics and buckets disappear too, but counters which are
+// not tracked metrics, such as those of Reporter.Inc, are kept.
+//
+// The Reporter only starts to accumulate counter values once
+// PrometheusHandler has been called.
+func (r *Reporter) PrometheusHandler() http.Handler {
+	r.lock()
+	if r.prom == nil {
+		r.prom = &promState{
+			series:  map[string]*promSeries{},
+			buckets: map[bucket]*promBucket{},
+		}
+	}
+	r.unlock()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		families := r.promFamilies()
+
+		names := make([]string, 0, len(families))
+		for name := range families {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w.Header().Set("Content-Type", PrometheusContentType)
+		for _, name := range names {
+			series := families[name]
+			sort.Slice(series, func(i, j int) bool {
+				if series[i].name != series[j].name {
+					return series[i].name < series[j].name
+				}
+				return series[i].labels < series[j].labels
+			})
+
+			// all series of a family must have the same type;
+			// those which don't are skipped
+			typ := series[0].typ
+			fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+			for _, s := range series {
+				if s.typ != typ {
+					continue
+				}
+				fmt.Fprintf(w, "%s%s %d\n", s.name, s.labels, s.value)
+			}
+		}
+	})
+}
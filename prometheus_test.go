@@ -0,0 +1,145 @@
+package signalfx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestPrometheus(t *testing.T) {
+	Convey("Testing Reporter.PrometheusHandler", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`"OK"`))
+		}))
+		defer ts.Close()
+
+		config := NewConfig()
+		config.URL = ts.URL
+
+		reporter := NewReporter(config, map[string]string{"host": "a-1"})
+		reporter.SetPrefix("app.")
+		handler := reporter.PrometheusHandler()
+
+		scrape := func() string {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+			So(w.Header().Get("Content-Type"), ShouldEqual, PrometheusContentType)
+			return w.Body.String()
+		}
+
+		Convey("names and labels should be sanitized", func() {
+			So(promName("app.requests-total"), ShouldEqual, "app_requests_total")
+			So(promName("1st"), ShouldEqual, "_st")
+			So(promName("a:b"), ShouldEqual, "a:b")
+			So(promLabels(nil), ShouldEqual, "")
+			So(promLabels(map[string]string{"b-key": `x"y`, "a": "1\n"}), ShouldEqual, `{a="1\n",b_key="x\"y"}`)
+		})
+
+		Convey("buckets named by suffix should accumulate", func() {
+			bucket := reporter.NewBucket("rtt", nil, BucketOptions{Naming: BucketMetricSuffix})
+			bucket.Add(5)
+			_, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+
+			bucket.Add(1)
+			out := scrape()
+			So(out, ShouldContainSubstring, "app_rtt_count{host=\"a-1\"} 2\n")
+			So(out, ShouldContainSubstring, "app_rtt_sum{host=\"a-1\"} 6\n")
+		})
+
+		Convey("stale series should be dropped", func() {
+			dps := []DataPoint{
+				{Metric: "pods", Type: GaugeType, Value: 3, Dimensions: map[string]string{"node": "n-1"}},
+				{Metric: "pods", Type: GaugeType, Value: 4, Dimensions: map[string]string{"node": "n-2"}},
+			}
+			reporter.AddDataPointsCallback(func() []DataPoint { return dps })
+			gauge := NewGauge("queue-depth", nil, 7)
+			reporter.Track(gauge)
+			So(reporter.Record("temperature", nil, 20), ShouldBeNil)
+			So(reporter.Inc("restarts", nil, 1), ShouldBeNil)
+
+			_, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			out := scrape()
+			So(out, ShouldContainSubstring, `app_pods{host="a-1",node="n-2"} 4`)
+			So(out, ShouldContainSubstring, `app_queue_depth{host="a-1"} 7`)
+			So(out, ShouldContainSubstring, `app_temperature{host="a-1"} 20`)
+
+			dps = dps[:1]
+			reporter.Untrack(gauge)
+			_, err = reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			out = scrape()
+			So(out, ShouldContainSubstring, `app_pods{host="a-1",node="n-1"} 3`)
+			So(out, ShouldNotContainSubstring, "n-2")
+			So(out, ShouldNotContainSubstring, "app_queue_depth")
+			So(out, ShouldNotContainSubstring, "app_temperature")
+			So(out, ShouldContainSubstring, `app_restarts{host="a-1"} 1`)
+		})
+
+		Convey("metrics should be converted", func() {
+			counter := NewCounter("requests", map[string]string{"code": "200"}, 0)
+			gauge := NewGauge("queue-depth", nil, 7)
+			cumulative := NewCumulativeCounter("bytes", nil, 100)
+			reporter.Track(counter, gauge, cumulative)
+			bucket := reporter.NewBucket("latency", nil)
+
+			counter.Inc(3)
+			bucket.Add(2)
+			bucket.Add(4)
+
+			So(scrape(), ShouldEqual, `# TYPE app_bytes counter
+app_bytes{host="a-1"} 100
+# TYPE app_latency summary
+app_latency_count{host="a-1"} 2
+app_latency_sum{host="a-1"} 6
+# TYPE app_latency_max gauge
+app_latency_max{host="a-1"} 4
+# TYPE app_latency_min gauge
+app_latency_min{host="a-1"} 2
+# TYPE app_queue_depth gauge
+app_queue_depth{host="a-1"} 7
+# TYPE app_requests counter
+app_requests{code="200",host="a-1"} 3
+`)
+
+			Convey("reporting to SignalFx should continue to work", func() {
+				dps, err := reporter.Report(context.Background())
+				So(err, ShouldBeNil)
+				So(len(dps), ShouldEqual, 8)
+
+				// counters keep accumulating across reports,
+				// unchanged cumulative counters are still
+				// exposed
+				counter.Inc(2)
+				bucket.Add(10)
+				So(scrape(), ShouldEqual, `# TYPE app_bytes counter
+app_bytes{host="a-1"} 100
+# TYPE app_latency summary
+app_latency_count{host="a-1"} 3
+app_latency_sum{host="a-1"} 16
+# TYPE app_latency_max gauge
+app_latency_max{host="a-1"} 10
+# TYPE app_latency_min gauge
+app_latency_min{host="a-1"} 10
+# TYPE app_queue_depth gauge
+app_queue_depth{host="a-1"} 7
+# TYPE app_requests counter
+app_requests{code="200",host="a-1"} 5
+`)
+
+				// scraping should not have affected the report
+				dps, err = reporter.Report(context.Background())
+				So(err, ShouldBeNil)
+				for _, dp := range dps {
+					if dp.Metric == "requests" {
+						So(dp.Value, ShouldEqual, 2)
+					}
+				}
+			})
+		})
+	})
+}
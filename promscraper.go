@@ -0,0 +1,591 @@
+package signalfx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+const (
+	// DefaultPromScrapeTimeout is the timeout used for a PromTarget
+	// without one
+	DefaultPromScrapeTimeout = 10 * time.Second
+
+	// DefaultPromScrapeInterval is the interval at which a PromScraper
+	// scrapes its targets, unless changed with SetInterval
+	DefaultPromScrapeInterval = 10 * time.Second
+
+	// PromScrapeUpMetric is the gauge reported for each PromTarget, 1
+	// if it was scraped successfully and 0 otherwise
+	PromScrapeUpMetric = "prometheus-scrape-up"
+
+	// PromTargetDimension is the dimension key under which a
+	// PromTarget's URL is reported with PromScrapeUpMetric
+	PromTargetDimension = "target"
+
+	// PromNameLabel is the label holding the metric name while
+	// relabeling
+	PromNameLabel = "__name__"
+)
+
+// A PromTarget is a Prometheus text format endpoint to be scraped by a
+// PromScraper
+type PromTarget struct {
+	URL string
+
+	// Dimensions are added to every datapoint scraped from the
+	// target
+	Dimensions map[string]string
+
+	// Timeout bounds the whole scrape, including reading the
+	// response body. It defaults to DefaultPromScrapeTimeout.
+	Timeout time.Duration
+}
+
+// PromRelabelAction is the action taken by a PromRelabel rule
+type PromRelabelAction int
+
+const (
+	// PromRelabelReplace sets TargetLabel to the expanded Replacement
+	// if Regex matches, removing TargetLabel if the result is empty
+	PromRelabelReplace PromRelabelAction = iota
+
+	// PromRelabelKeep drops samples for which Regex does not match
+	PromRelabelKeep
+
+	// PromRelabelDrop drops samples for which Regex matches
+	PromRelabelDrop
+
+	// PromRelabelLabelDrop removes the labels whose names match Regex
+	PromRelabelLabelDrop
+
+	// PromRelabelScale multiplies the value of samples for which Regex
+	// matches by Factor, e.g. so that durations in seconds are not
+	// rounded to 0.  Labels, such as the le label of histograms, are
+	// not scaled.
+	PromRelabelScale
+)
+
+// A PromRelabel rule rewrites the labels of scraped samples before they
+// become datapoints, in the manner of Prometheus' metric_relabel_configs.
+// The metric name is available as the PromNameLabel label.
+type PromRelabel struct {
+	Action PromRelabelAction
+
+	// SourceLabels are the labels whose values, joined with ";", are
+	// matched against Regex. They are not used by
+	// PromRelabelLabelDrop, which matches label names instead.
+	SourceLabels []string
+
+	// Regex must match the whole value. A nil Regex matches
+	// anything.
+	Regex *regexp.Regexp
+
+	// TargetLabel and Replacement are used by PromRelabelReplace.
+	// Replacement may refer to submatches of Regex, e.g. "$1".
+	TargetLabel string
+	Replacement string
+
+	// Factor is used by PromRelabelScale
+	Factor float64
+}
+
+// match returns the submatch indices of Regex within value, or nil if it
+// does not match the whole value
+func (p PromRelabel) match(value string) []int {
+	if p.Regex == nil {
+		return []int{0, len(value)}
+	}
+	m := p.Regex.FindStringSubmatchIndex(value)
+	if m == nil || m[0] != 0 || m[1] != len(value) {
+		return nil
+	}
+	return m
+}
+
+// apply applies the rule to the labels and value of a sample, returning
+// false if the sample is to be dropped
+func (p PromRelabel) apply(labels map[string]string, value *float64) bool {
+	if p.Action == PromRelabelLabelDrop {
+		for k := range labels {
+			if k != PromNameLabel && p.match(k) != nil {
+				delete(labels, k)
+			}
+		}
+		return true
+	}
+
+	values := make([]string, len(p.SourceLabels))
+	for i, l := range p.SourceLabels {
+		values[i] = labels[l]
+	}
+	joined := strings.Join(values, ";")
+	m := p.match(joined)
+
+	switch p.Action {
+	case PromRelabelKeep:
+		return m != nil
+	case PromRelabelDrop:
+		return m == nil
+	case PromRelabelScale:
+		if m != nil {
+			*value *= p.Factor
+		}
+	case PromRelabelReplace:
+		if m == nil {
+			return true
+		}
+		var res []byte
+		if p.Regex != nil {
+			res = p.Regex.ExpandString(nil, p.Replacement, joined, m)
+		} else {
+			res = []byte(p.Replacement)
+		}
+		if len(res) == 0 {
+			delete(labels, p.TargetLabel)
+		} else {
+			labels[p.TargetLabel] = string(res)
+		}
+	}
+	return true
+}
+
+// A PromScraper reports the metrics exposed by Prometheus text format
+// endpoints. Every target is scraped concurrently, in the background,
+// every interval (DefaultPromScrapeInterval unless changed with
+// SetInterval), and as soon as targets are added; the Reporter reports
+// the results of the last scrape, so that it is never delayed by slow
+// targets.
+//
+// Samples are converted to datapoints: labels become dimensions,
+// counters and the _count, _sum and _bucket series of histograms and
+// summaries become cumulative counters (with a histogram's le label as
+// a dimension), and everything else, including summary quantiles,
+// becomes a gauge. Values are rounded to integers, so fractional ones,
+// such as durations in seconds, should be scaled with a
+// PromRelabelScale rule; NaN or infinite values are skipped.
+//
+// A PromScrapeUpMetric gauge is reported for each target as well.
+//
+// All operations on a PromScraper are goroutine safe.
+type PromScraper struct {
+	dimensions map[string]string
+	targets    []PromTarget
+	relabel    []PromRelabel
+	client     *http.Client
+	interval   time.Duration
+	errs       map[string]error
+	results    []DataPoint // of the last scrape
+	closed     bool
+	mu         sync.Mutex
+
+	// kick triggers a scrape, and done stops scraping
+	kick chan struct{}
+	done chan struct{}
+}
+
+// NewPromScraper returns a new PromScraper, which scrapes in the
+// background until closed, and adds it to the reporter as a
+// DataPointCallback. dims are added to every datapoint, and are
+// copied.
+func NewPromScraper(reporter *Reporter, dims map[string]string) *PromScraper {
+	ret := &PromScraper{
+		dimensions: sfxproto.Dimensions(dims).Clone(),
+		client:     &http.Client{},
+		interval:   DefaultPromScrapeInterval,
+		errs:       map[string]error{},
+		kick:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	reporter.AddDataPointsCallback(ret.DataPoints)
+	go ret.run()
+	return ret
+}
+
+// run scrapes every interval, or when kicked, until done
+func (s *PromScraper) run() {
+	for {
+		s.mu.Lock()
+		interval := s.interval
+		s.mu.Unlock()
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case <-s.kick:
+			timer.Stop()
+		case <-timer.C:
+		}
+		s.Scrape()
+	}
+}
+
+// AddTarget adds targets to be scraped, and scrapes them. Their
+// dimensions are copied.
+func (s *PromScraper) AddTarget(targets ...PromTarget) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range targets {
+		t.Dimensions = sfxproto.Dimensions(t.Dimensions).Clone()
+		s.targets = append(s.targets, t)
+	}
+
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// SetInterval sets the interval at which targets are scraped, from the
+// next scrape on
+func (s *PromScraper) SetInterval(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.interval = d
+}
+
+// SetRelabel sets the rules applied, in order, to every scraped sample
+func (s *PromScraper) SetRelabel(rules ...PromRelabel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.relabel = rules
+}
+
+// SetTransport sets the http.RoundTripper used to scrape targets. It
+// defaults to http.DefaultTransport.
+func (s *PromScraper) SetTransport(tr http.RoundTripper) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.client = &http.Client{Transport: tr}
+}
+
+// Errors returns the error of the last scrape of each target which
+// failed, keyed by URL
+func (s *PromScraper) Errors() map[string]error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := make(map[string]error, len(s.errs))
+	for k, v := range s.errs {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Close stops the PromScraper from scraping and being reported.
+// Implements the io.Closer interface.
+func (s *PromScraper) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	return nil
+}
+
+// DataPoints returns the datapoints of the last scrape, without
+// scraping.  It is normally only called by Reporter.Report.
+func (s *PromScraper) DataPoints() []DataPoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	return append([]DataPoint(nil), s.results...)
+}
+
+// Scrape scrapes every target now, and waits for the results, which
+// DataPoints returns until the next scrape.  It is normally only
+// called in the background.
+func (s *PromScraper) Scrape() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	targets := s.targets
+	relabel := s.relabel
+	client := s.client
+	s.mu.Unlock()
+
+	results := make([][]DataPoint, len(targets))
+	errs := make([]error, len(targets))
+
+	var wg sync.WaitGroup
+	wg.Add(len(targets))
+	for i := range targets {
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = s.scrape(client, targets[i], relabel)
+		}(i)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ret []DataPoint
+	timestamp := time.Now()
+	for i, t := range targets {
+		up := int64(1)
+		if errs[i] != nil {
+			up = 0
+			s.errs[t.URL] = errs[i]
+		} else {
+			delete(s.errs, t.URL)
+		}
+
+		ret = append(ret, results[i]...)
+		ret = append(ret, DataPoint{
+			Metric:    PromScrapeUpMetric,
+			Type:      GaugeType,
+			Value:     up,
+			Timestamp: timestamp,
+			Dimensions: sfxproto.Dimensions(s.dimensions).Append(t.Dimensions).Append(map[string]string{
+				PromTargetDimension: t.URL,
+			}),
+		})
+	}
+	s.results = ret
+}
+
+// scrape fetches and converts the samples of a single target
+func (s *PromScraper) scrape(client *http.Client, t PromTarget, relabel []PromRelabel) ([]DataPoint, error) {
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = DefaultPromScrapeTimeout
+	}
+	c := *client
+	c.Timeout = timeout
+
+	req, err := http.NewRequest("GET", t.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	samples, err := parsePromText(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	dims := sfxproto.Dimensions(s.dimensions).Append(t.Dimensions)
+	timestamp := time.Now()
+	ret := make([]DataPoint, 0, len(samples))
+
+SAMPLES:
+	for _, sample := range samples {
+		if math.IsNaN(sample.value) {
+			continue
+		}
+
+		sample.labels[PromNameLabel] = sample.name
+		for _, rule := range relabel {
+			if !rule.apply(sample.labels, &sample.value) {
+				continue SAMPLES
+			}
+		}
+		name := sample.labels[PromNameLabel]
+		delete(sample.labels, PromNameLabel)
+		if name == "" || sample.value >= math.MaxInt64 || sample.value < math.MinInt64 {
+			continue
+		}
+
+		ret = append(ret, DataPoint{
+			Metric:     name,
+			Type:       sample.metricType(),
+			Value:      int64(math.Round(sample.value)),
+			Timestamp:  timestamp,
+			Dimensions: dims.Append(sample.labels),
+		})
+	}
+	return ret, nil
+}
+
+// promSample is a single sample parsed from the Prometheus text format
+type promSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+
+	// typ is the type of the sample's metric family, e.g. "counter"
+	// or "histogram"
+	typ string
+}
+
+// metricType returns the MetricType of the sample
+func (s promSample) metricType() MetricType {
+	switch s.typ {
+	case "counter":
+		return CumulativeCounterType
+	case "histogram", "summary":
+		if _, ok := s.labels["quantile"]; !ok {
+			return CumulativeCounterType
+		}
+	}
+	return GaugeType
+}
+
+var errPromFormat = errors.New("unexpected prometheus text format")
+
+// parsePromText parses the Prometheus text exposition format
+func parsePromText(r io.Reader) ([]promSample, error) {
+	types := map[string]string{}
+	var ret []promSample
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if line[0] == '#' {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		sample, err := parsePromSample(line)
+		if err != nil {
+			return nil, err
+		}
+
+		if typ, ok := types[sample.name]; ok {
+			sample.typ = typ
+		} else {
+			for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+				if !strings.HasSuffix(sample.name, suffix) {
+					continue
+				}
+				typ := types[strings.TrimSuffix(sample.name, suffix)]
+				if typ == "histogram" || typ == "summary" && suffix != "_bucket" {
+					sample.typ = typ
+				}
+			}
+		}
+
+		ret = append(ret, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// parsePromSample parses a single sample line, i.e.
+// name{label="value",...} value [timestamp]
+func parsePromSample(line string) (promSample, error) {
+	ret := promSample{labels: map[string]string{}}
+
+	i := strings.IndexAny(line, "{ \t")
+	if i <= 0 {
+		return ret, errPromFormat
+	}
+	ret.name = line[:i]
+	line = line[i:]
+
+	if line[0] == '{' {
+		line = line[1:]
+		for {
+			line = strings.TrimLeft(line, " \t")
+			if line == "" {
+				return ret, errPromFormat
+			}
+			if line[0] == '}' {
+				line = line[1:]
+				break
+			}
+
+			i = strings.Index(line, "=")
+			if i <= 0 {
+				return ret, errPromFormat
+			}
+			key := strings.TrimSpace(line[:i])
+			line = strings.TrimLeft(line[i+1:], " \t")
+			if line == "" || line[0] != '"' {
+				return ret, errPromFormat
+			}
+
+			value, rest, err := parsePromLabelValue(line[1:])
+			if err != nil {
+				return ret, err
+			}
+			ret.labels[key] = value
+
+			line = strings.TrimLeft(rest, " \t")
+			if strings.HasPrefix(line, ",") {
+				line = line[1:]
+			}
+		}
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 {
+		return ret, errPromFormat
+	}
+
+	var err error
+	if ret.value, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return ret, errPromFormat
+	}
+	return ret, nil
+}
+
+// parsePromLabelValue parses an escaped label value up to its closing
+// quote, returning the value and the remainder of s
+func parsePromLabelValue(s string) (string, string, error) {
+	var value []byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return string(value), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", errPromFormat
+			}
+			switch s[i] {
+			case 'n':
+				value = append(value, '\n')
+			default:
+				value = append(value, s[i])
+			}
+		default:
+			value = append(value, s[i])
+		}
+	}
+	return "", "", errPromFormat
+}
@@ -0,0 +1,230 @@
+package signalfx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const promScraperTestText = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# TYPE queue_depth gauge
+queue_depth 4.6
+untyped_value{path="C:\\DIR\\",msg="say \"hi\"\n"} 1
+nan_value NaN
+
+# TYPE rpc_duration_seconds histogram
+rpc_duration_seconds_bucket{le="0.05"} 24054
+rpc_duration_seconds_bucket{le="+Inf"} 144320
+rpc_duration_seconds_sum 53423.7
+rpc_duration_seconds_count 144320
+
+# TYPE gc_seconds summary
+gc_seconds{quantile="0.5"} 2
+gc_seconds_sum 17.5
+gc_seconds_count 9
+`
+
+func TestPromScraper(t *testing.T) {
+	Convey("Testing PromScraper", t, func() {
+		Convey("it should parse the text format", func() {
+			samples, err := parsePromText(strings.NewReader(promScraperTestText))
+			So(err, ShouldBeNil)
+			So(len(samples), ShouldEqual, 12)
+
+			So(samples[0].name, ShouldEqual, "http_requests_total")
+			So(samples[0].labels, ShouldResemble, map[string]string{"method": "post", "code": "200"})
+			So(samples[0].value, ShouldEqual, 1027)
+			So(samples[0].metricType(), ShouldEqual, CumulativeCounterType)
+
+			So(samples[3].labels, ShouldResemble, map[string]string{"path": `C:\DIR\`, "msg": "say \"hi\"\n"})
+			So(samples[3].metricType(), ShouldEqual, GaugeType)
+
+			So(samples[5].labels["le"], ShouldEqual, "0.05")
+			So(samples[5].metricType(), ShouldEqual, CumulativeCounterType)
+			So(samples[8].metricType(), ShouldEqual, CumulativeCounterType)
+
+			So(samples[9].metricType(), ShouldEqual, GaugeType)
+			So(samples[10].metricType(), ShouldEqual, CumulativeCounterType)
+
+			for _, text := range []string{"{a=\"1\"} 1", "a{b=1} 1", "a{b=\"1} 1", "a one", "a 1 2 3"} {
+				_, err = parsePromText(strings.NewReader(text))
+				So(err, ShouldEqual, errPromFormat)
+			}
+		})
+
+		var scrapes Atomic[int64]
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/metrics":
+				scrapes.Inc(1)
+				_, _ = w.Write([]byte(promScraperTestText))
+			case "/slow":
+				time.Sleep(200 * time.Millisecond)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer ts.Close()
+
+		reporter := NewReporter(NewConfig(), nil)
+		scraper := NewPromScraper(reporter, map[string]string{"pod": "p-1"})
+		defer scraper.Close()
+		scraper.AddTarget(PromTarget{
+			URL:        ts.URL + "/metrics",
+			Dimensions: map[string]string{"job": "sidecar"},
+		})
+
+		find := func(dps []DataPoint, metric string, dims map[string]string) *DataPoint {
+			for i, dp := range dps {
+				if dp.Metric != metric {
+					continue
+				}
+				ok := true
+				for k, v := range dims {
+					ok = ok && dp.Dimensions[k] == v
+				}
+				if ok {
+					return &dps[i]
+				}
+			}
+			return nil
+		}
+
+		Convey("it should scrape in the background", func() {
+			deadline := time.Now().Add(time.Second)
+			for len(scraper.DataPoints()) == 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			So(len(scraper.DataPoints()), ShouldEqual, 12)
+
+			// DataPoints reports the cached results
+			n := scrapes.Value()
+			scraper.DataPoints()
+			So(scrapes.Value(), ShouldEqual, n)
+		})
+
+		Convey("it should convert samples to datapoints", func() {
+			scraper.Scrape()
+			dps := scraper.DataPoints()
+			// the NaN value is skipped, and up is added
+			So(len(dps), ShouldEqual, 12)
+
+			dp := find(dps, "http_requests_total", map[string]string{"code": "400"})
+			So(dp, ShouldNotBeNil)
+			So(dp.Type, ShouldEqual, CumulativeCounterType)
+			So(dp.Value, ShouldEqual, 3)
+			So(dp.Dimensions, ShouldResemble, map[string]string{
+				"pod":    "p-1",
+				"job":    "sidecar",
+				"method": "post",
+				"code":   "400",
+			})
+
+			dp = find(dps, "queue_depth", nil)
+			So(dp, ShouldNotBeNil)
+			So(dp.Type, ShouldEqual, GaugeType)
+			So(dp.Value, ShouldEqual, 5)
+
+			dp = find(dps, "rpc_duration_seconds_bucket", map[string]string{"le": "+Inf"})
+			So(dp, ShouldNotBeNil)
+			So(dp.Value, ShouldEqual, 144320)
+
+			dp = find(dps, PromScrapeUpMetric, map[string]string{PromTargetDimension: ts.URL + "/metrics"})
+			So(dp, ShouldNotBeNil)
+			So(dp.Value, ShouldEqual, 1)
+			So(scraper.Errors(), ShouldBeEmpty)
+		})
+
+		Convey("it should relabel samples", func() {
+			scraper.SetRelabel(
+				PromRelabel{
+					Action:       PromRelabelKeep,
+					SourceLabels: []string{PromNameLabel},
+					Regex:        regexp.MustCompile("http_.*|queue_depth"),
+				},
+				PromRelabel{
+					Action:       PromRelabelDrop,
+					SourceLabels: []string{"code"},
+					Regex:        regexp.MustCompile("4.."),
+				},
+				PromRelabel{
+					Action:       PromRelabelReplace,
+					SourceLabels: []string{PromNameLabel},
+					Regex:        regexp.MustCompile("http_(.*)"),
+					TargetLabel:  PromNameLabel,
+					Replacement:  "sidecar.$1",
+				},
+				PromRelabel{
+					Action: PromRelabelLabelDrop,
+					Regex:  regexp.MustCompile("method"),
+				},
+			)
+
+			scraper.Scrape()
+			dps := scraper.DataPoints()
+			So(len(dps), ShouldEqual, 3)
+
+			dp := find(dps, "sidecar.requests_total", nil)
+			So(dp, ShouldNotBeNil)
+			So(dp.Value, ShouldEqual, 1027)
+			So(dp.Dimensions, ShouldResemble, map[string]string{
+				"pod":  "p-1",
+				"job":  "sidecar",
+				"code": "200",
+			})
+			So(find(dps, "queue_depth", nil), ShouldNotBeNil)
+		})
+
+		Convey("it should scale samples", func() {
+			scraper.SetRelabel(PromRelabel{
+				Action:       PromRelabelScale,
+				SourceLabels: []string{PromNameLabel},
+				Regex:        regexp.MustCompile(".*_seconds_sum"),
+				Factor:       1000,
+			})
+
+			scraper.Scrape()
+			dps := scraper.DataPoints()
+			So(find(dps, "rpc_duration_seconds_sum", nil).Value, ShouldEqual, 53423700)
+			So(find(dps, "gc_seconds_sum", nil).Value, ShouldEqual, 17500)
+			So(find(dps, "gc_seconds_count", nil).Value, ShouldEqual, 9)
+		})
+
+		Convey("failed scrapes should be reported", func() {
+			scraper.AddTarget(
+				PromTarget{URL: ts.URL + "/slow", Timeout: 50 * time.Millisecond},
+				PromTarget{URL: ts.URL + "/missing"},
+			)
+
+			scraper.Scrape()
+			dps := scraper.DataPoints()
+			So(len(dps), ShouldEqual, 14)
+
+			for _, path := range []string{"/slow", "/missing"} {
+				dp := find(dps, PromScrapeUpMetric, map[string]string{PromTargetDimension: ts.URL + path})
+				So(dp, ShouldNotBeNil)
+				So(dp.Value, ShouldEqual, 0)
+			}
+
+			errs := scraper.Errors()
+			So(len(errs), ShouldEqual, 2)
+			So(errs[ts.URL+"/missing"].Error(), ShouldContainSubstring, "404")
+		})
+
+		Convey("it should stop when closed", func() {
+			scraper.Scrape()
+			So(scraper.Close(), ShouldBeNil)
+			So(scraper.DataPoints(), ShouldBeNil)
+			So(scraper.Close(), ShouldBeNil)
+		})
+	})
+}
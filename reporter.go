@@ -19,7 +19,10 @@ type Metric interface {
 	// point in time.  If it has no value at the present point in
 	// time, return nil.  A Reporter will add its metric prefix
 	// and default dimensions to the metric and dimensions
-	// indicated in the DataPoint.
+	// indicated in the DataPoint.  DataPoint is also called by
+	// Reporter.Snapshot and the Reporter's handlers, so it should
+	// not change any state which affects later reports; such
+	// changes belong in PostReportHook.
 	DataPoint() *DataPoint
 }
 
@@ -44,6 +47,31 @@ type HookedMetric interface {
 	PostReportHook(reportedValue int64)
 }
 
+// An intervalMetric is told of each reporting interval, just before
+// Reporter.Report calls its DataPoint method, so that it may tell them
+// apart from other calls, such as those of Reporter.Snapshot.
+type intervalMetric interface {
+	Metric
+	reportInterval()
+}
+
+// A snapshottingMetric's DataPoint sets aside what it reports until
+// PostReportHook, so snapshot returns the same DataPoint without doing
+// so, for Reporter.Snapshot.
+type snapshottingMetric interface {
+	Metric
+	snapshot() *DataPoint
+}
+
+// snapshotOf returns the current DataPoint of metric without changing
+// its state
+func snapshotOf(metric Metric) *DataPoint {
+	if m, ok := metric.(snapshottingMetric); ok {
+		return m.snapshot()
+	}
+	return metric.DataPoint()
+}
+
 // DataPointCallback is a functional callback that can be passed to
 // DataPointCallback as a way to have the caller calculate and return
 // their own datapoints
@@ -56,13 +84,19 @@ type Reporter struct {
 	defaultDimensions map[string]string
 	//datapoints         *DataPoints
 	metrics            map[Metric]struct{}
-	buckets            map[*Bucket]interface{}
+	buckets            map[bucket]interface{}
+	windows            map[*Window]struct{}
 	preReportCallbacks []func()
 	datapointCallbacks []DataPointCallback
 	mu                 sync.Mutex
 	oneShots           []DataPoint
 	metricPrefix       string
 	logger             io.Writer
+	prom               *promState
+
+	// encodedDimensions are the default dimensions, encoded once for
+	// every datapoint reported; nil when they must be encoded again
+	encodedDimensions []byte
 }
 
 // NewReporter returns a new Reporter object. Any dimensions supplied will be
@@ -73,7 +107,8 @@ func NewReporter(config *Config,
 	return &Reporter{
 		client:            NewClient(config),
 		defaultDimensions: defaultDimensions,
-		buckets:           map[*Bucket]interface{}{},
+		buckets:           map[bucket]interface{}{},
+		windows:           map[*Window]struct{}{},
 		metrics:           map[Metric]struct{}{},
 		logger:            config.Logger,
 	}
@@ -103,6 +138,7 @@ func (r *Reporter) SetDimension(key, value string) {
 	defer r.unlock()
 
 	r.defaultDimensions[key] = value
+	r.encodedDimensions = nil
 }
 
 // DeleteDimension deletes a default dimension.
@@ -111,6 +147,7 @@ func (r *Reporter) DeleteDimension(key string) {
 	defer r.unlock()
 
 	delete(r.defaultDimensions, key)
+	r.encodedDimensions = nil
 }
 
 // Track adds a Metric to a Reporter's set of tracked Metrics.  Its
@@ -135,10 +172,11 @@ func (r *Reporter) Untrack(m ...Metric) {
 	}
 }
 
-// NewBucket creates a new Bucket object that is tracked by the Reporter.
-// Buckets are goroutine safe.
-func (r *Reporter) NewBucket(metric string, dimensions map[string]string) *Bucket {
-	ret := NewBucket(metric, dimensions)
+// NewBucket creates a new Bucket object that is tracked by the Reporter,
+// configured by the first of options, if any.  Buckets are goroutine
+// safe.
+func (r *Reporter) NewBucket(metric string, dimensions map[string]string, options ...BucketOptions) *Bucket {
+	ret := NewBucket(metric, dimensions, options...)
 
 	r.lock()
 	defer r.unlock()
@@ -157,6 +195,29 @@ func (r *Reporter) RemoveBucket(bs ...*Bucket) {
 	}
 }
 
+// NewShardedBucket creates a new ShardedBucket object that is tracked
+// by the Reporter, configured by the first of options, if any.
+func (r *Reporter) NewShardedBucket(metric string, dimensions map[string]string, options ...BucketOptions) *ShardedBucket {
+	ret := NewShardedBucket(metric, dimensions, options...)
+
+	r.lock()
+	defer r.unlock()
+
+	r.buckets[ret] = nil
+	return ret
+}
+
+// RemoveShardedBucket takes ShardedBucket(s) out of the set being
+// tracked by the Reporter
+func (r *Reporter) RemoveShardedBucket(bs ...*ShardedBucket) {
+	r.lock()
+	defer r.unlock()
+
+	for _, b := range bs {
+		delete(r.buckets, b)
+	}
+}
+
 // AddPreReportCallback adds a function that is called before
 // Report().  This is useful for refetching things like
 // runtime.Memstats() so they are only fetched once per report()
@@ -189,17 +250,6 @@ func (r *Reporter) Report(ctx context.Context) ([]DataPoint, error) {
 	r.lock()
 	defer r.unlock()
 
-	dimensions := make([]*sfxproto.Dimension, 0, len(r.defaultDimensions))
-	for k, v := range r.defaultDimensions {
-		// have to copy the values, since these are stored as
-		// pointers…
-		var dk, dv string
-		dk = k
-		dv = v
-		dimensions = append(dimensions,
-			&sfxproto.Dimension{Key: &dk, Value: &dv})
-	}
-
 	for _, f := range r.preReportCallbacks {
 		f()
 	}
@@ -219,13 +269,35 @@ func (r *Reporter) Report(ctx context.Context) ([]DataPoint, error) {
 	for _, f := range r.datapointCallbacks {
 		ret = append(ret, f()...)
 	}
+	for w := range r.windows {
+		if w.isClosed() {
+			delete(r.windows, w)
+			continue
+		}
+		ret = append(ret, w.DataPoints()...)
+	}
+	callbacksEnd := len(ret)
 
+	var bucketDPs map[bucket][]DataPoint
+	if r.prom != nil {
+		bucketDPs = make(map[bucket][]DataPoint, len(r.buckets))
+	}
 	for b := range r.buckets {
-		ret = append(ret, b.DataPoints()...)
+		dps := b.DataPoints()
+		if bucketDPs != nil {
+			bucketDPs[b] = dps
+		}
+		ret = append(ret, dps...)
 	}
+	bucketsEnd := len(ret)
 
 	// append all of the one-shots
 	ret = append(ret, r.oneShots...)
+	oneShotsEnd := len(ret)
+
+	// the metric of each datapoint from ret[oneShotsEnd:], for
+	// Prometheus
+	var reportedMetrics []Metric
 
 	var hookedMetrics []struct {
 		m HookedMetric
@@ -234,11 +306,17 @@ func (r *Reporter) Report(ctx context.Context) ([]DataPoint, error) {
 
 	// append all of the tracked metrics
 	for metric := range r.metrics {
+		if m, ok := metric.(intervalMetric); ok {
+			m.reportInterval()
+		}
 		dp := metric.DataPoint()
 		if dp == nil {
 			continue
 		}
 		ret = append(ret, *dp)
+		if r.prom != nil {
+			reportedMetrics = append(reportedMetrics, metric)
+		}
 		if m, ok := metric.(HookedMetric); ok {
 			hm := struct {
 				m HookedMetric
@@ -255,14 +333,12 @@ func (r *Reporter) Report(ctx context.Context) ([]DataPoint, error) {
 		return nil, nil
 	}
 
-	pdps := sfxproto.NewDataPoints(len(ret))
-	for _, dp := range ret {
-		pdp := dp.protoDataPoint(r.metricPrefix, dimensions)
-		pdps.Add(pdp)
+	if err := r.submit(ctx, ret); err != nil {
+		return nil, err
 	}
 
-	if err := r.client.Submit(ctx, pdps); err != nil {
-		return nil, err
+	if r.prom != nil {
+		r.prom.reported(r, ret[:callbacksEnd], ret[bucketsEnd:oneShotsEnd], ret[oneShotsEnd:], reportedMetrics, bucketDPs)
 	}
 
 	// reset resettable metrics
@@ -276,6 +352,115 @@ func (r *Reporter) Report(ctx context.Context) ([]DataPoint, error) {
 	return ret, nil
 }
 
+// submit sends dps to SignalFx.  Unless the Client is configured to
+// sort or coalesce datapoints, which requires them as sfxproto values,
+// they are encoded directly into a pooled buffer.  r.mu must be held.
+func (r *Reporter) submit(ctx context.Context, dps []DataPoint) error {
+	if r.client.config.SortDataPoints || r.client.config.CoalesceDataPoints {
+		dimensions := make([]*sfxproto.Dimension, 0, len(r.defaultDimensions))
+		for k, v := range r.defaultDimensions {
+			// have to copy the values, since these are stored as
+			// pointers…
+			var dk, dv string
+			dk = k
+			dv = v
+			dimensions = append(dimensions,
+				&sfxproto.Dimension{Key: &dk, Value: &dv})
+		}
+
+		pdps := sfxproto.NewDataPoints(len(dps))
+		for _, dp := range dps {
+			pdp := dp.protoDataPoint(r.metricPrefix, dimensions)
+			pdps.Add(pdp)
+		}
+		return r.client.Submit(ctx, pdps)
+	}
+
+	if r.encodedDimensions == nil {
+		r.encodedDimensions = encodeDimensions(r.defaultDimensions)
+		if r.encodedDimensions == nil {
+			r.encodedDimensions = []byte{}
+		}
+	}
+
+	buf := encoderPool.Get().(*[]byte)
+	*buf = appendDataPoints((*buf)[:0], r.metricPrefix, r.encodedDimensions, dps)
+	if len(*buf) == 0 {
+		encoderPool.Put(buf)
+		return ErrMarshal(sfxproto.ErrMarshalNoData)
+	}
+
+	return r.client.submit(ctx, *buf, func() { encoderPool.Put(buf) })
+}
+
+// Snapshot sources, as reported by Reporter.Handler
+const (
+	snapshotMetric   = "metric"
+	snapshotBucket   = "bucket"
+	snapshotCallback = "callback"
+	snapshotWindow   = "window"
+	snapshotOneShot  = "one-shot"
+)
+
+// snapshotDataPoint is a DataPoint along with where it came from
+type snapshotDataPoint struct {
+	DataPoint
+	Source string
+}
+
+// Snapshot returns the DataPoints which Report would send at this
+// point in time, with the metric prefix and default dimensions
+// applied.  Unlike Report, it neither runs PreReportCallbacks, calls
+// PostReportHook nor resets Buckets.  DataPointCallbacks are only
+// called if callbacks is true, since a callback may reset its own
+// state each time it is called.
+func (r *Reporter) Snapshot(callbacks bool) []DataPoint {
+	sdps := r.snapshot(callbacks)
+	ret := make([]DataPoint, len(sdps))
+	for i, sdp := range sdps {
+		ret[i] = sdp.DataPoint
+	}
+	return ret
+}
+
+func (r *Reporter) snapshot(callbacks bool) []snapshotDataPoint {
+	r.lock()
+	defer r.unlock()
+
+	var ret []snapshotDataPoint
+	add := func(source string, dps ...DataPoint) {
+		for _, dp := range dps {
+			dp.Metric = r.metricPrefix + dp.Metric
+			dp.Dimensions = sfxproto.Dimensions(r.defaultDimensions).Append(dp.Dimensions)
+			ret = append(ret, snapshotDataPoint{dp, source})
+		}
+	}
+
+	if callbacks {
+		for _, f := range r.datapointCallbacks {
+			add(snapshotCallback, f()...)
+		}
+	}
+
+	for b := range r.buckets {
+		add(snapshotBucket, b.snapshot()...)
+	}
+
+	for w := range r.windows {
+		add(snapshotWindow, w.DataPoints()...)
+	}
+
+	add(snapshotOneShot, r.oneShots...)
+
+	for metric := range r.metrics {
+		if dp := snapshotOf(metric); dp != nil {
+			add(snapshotMetric, *dp)
+		}
+	}
+
+	return ret
+}
+
 // Add adds a single DataPoint to a Reporter; it will be reported and,
 // once successfully reported, deleted.
 func (r *Reporter) Add(dp DataPoint) {
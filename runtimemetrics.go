@@ -0,0 +1,221 @@
+package signalfx
+
+import (
+	"math"
+	"runtime/metrics"
+	"strings"
+	"sync"
+
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+// RuntimeMetricQuantiles are the quantiles reported for each
+// runtime/metrics histogram, as the value of the "quantile" dimension.
+// "max" is the upper bound of the highest populated bucket.
+var RuntimeMetricQuantiles = []struct {
+	Name     string
+	Quantile float64
+}{
+	{"50", 0.5},
+	{"90", 0.9},
+	{"99", 0.99},
+	{"max", 1},
+}
+
+// RuntimeMetrics gathers and reports every metric supported by the
+// runtime/metrics package for the reporter.  Unlike GoMetrics it does
+// not stop the world to do so.
+//
+// Metric names are derived from the runtime/metrics names, e.g.
+// /gc/heap/allocs:bytes is reported as go-runtime-gc-heap-allocs-bytes.
+// Values in seconds are reported in nanoseconds instead (with an -ns
+// suffix), since datapoints are integers.  Cumulative metrics are
+// reported as cumulative counters, others as gauges.  Histograms, such
+// as /gc/pauses:seconds, are reported as gauges for each of
+// RuntimeMetricQuantiles, computed over the samples recorded since the
+// previous report, with a "quantile" dimension.
+type RuntimeMetrics struct {
+	metrics  []Metric
+	reporter *Reporter
+}
+
+// runtimeMetricName converts a runtime/metrics name into a SignalFx
+// metric name, also returning the factor by which its values must be
+// multiplied
+func runtimeMetricName(name string) (string, float64) {
+	scale := float64(1)
+	i := strings.LastIndex(name, ":")
+	unit := name[i+1:]
+	if strings.HasSuffix(unit, "seconds") {
+		unit = strings.TrimSuffix(unit, "seconds") + "ns"
+		scale = 1e9
+	}
+	name = strings.Trim(name[:i], "/") + "/" + unit
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', ':', '_', '.', '*':
+			return '-'
+		}
+		return r
+	}, name)
+	return "go-runtime-" + name, scale
+}
+
+// NewRuntimeMetrics registers the reporter to report runtime/metrics
+// values.  You should provide enough dims to differentiate this set of
+// metrics.  If allow is non-empty, only runtime/metrics names matching
+// one of its patterns are reported; names matching one of the deny
+// patterns are never reported.  Patterns are matched with path.Match,
+// e.g. "/gc/*/*:bytes" or "/sched/latencies:seconds".
+func NewRuntimeMetrics(reporter *Reporter, dims map[string]string, allow, deny []string) *RuntimeMetrics {
+	var mu sync.Mutex
+	var samples []metrics.Sample
+	// values holds the current value of each reported datapoint,
+	// keyed by its index in ret.metrics; absent values are not
+	// reported
+	values := map[int]int64{}
+	// previous holds the bucket counts of each histogram at the
+	// previous report, keyed by its index in samples
+	previous := map[int][]uint64{}
+
+	ret := &RuntimeMetrics{
+		reporter: reporter,
+	}
+
+	getter := func(i int) Getter {
+		return GetterFunc(func() (interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			v, ok := values[i]
+			if !ok {
+				return nil, ErrNotAvailable
+			}
+			return v, nil
+		})
+	}
+
+	// first holds the index into ret.metrics of the first (or
+	// only) datapoint of each sample
+	var first []int
+	var scales []float64
+
+	for _, desc := range metrics.All() {
+		if len(allow) > 0 && !matchAny(desc.Name, allow) {
+			continue
+		}
+		if matchAny(desc.Name, deny) {
+			continue
+		}
+
+		name, scale := runtimeMetricName(desc.Name)
+		i := len(ret.metrics)
+
+		switch desc.Kind {
+		case metrics.KindUint64, metrics.KindFloat64:
+			if desc.Cumulative {
+				ret.metrics = append(ret.metrics, WrapCumulativeCounter(name, dims, getter(i)))
+			} else {
+				ret.metrics = append(ret.metrics, WrapGauge(name, dims, getter(i)))
+			}
+		case metrics.KindFloat64Histogram:
+			for j, q := range RuntimeMetricQuantiles {
+				qdims := sfxproto.Dimensions(dims).Append(map[string]string{"quantile": q.Name})
+				ret.metrics = append(ret.metrics, WrapGauge(name, qdims, getter(i+j)))
+			}
+		default:
+			continue
+		}
+
+		samples = append(samples, metrics.Sample{Name: desc.Name})
+		first = append(first, i)
+		scales = append(scales, scale)
+	}
+	reporter.Track(ret.metrics...)
+
+	reporter.AddPreReportCallback(func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		metrics.Read(samples)
+		values = map[int]int64{}
+
+		for s, sample := range samples {
+			i, scale := first[s], scales[s]
+
+			switch sample.Value.Kind() {
+			case metrics.KindUint64:
+				if v := sample.Value.Uint64(); v > math.MaxInt64 {
+					continue
+				} else if scale == 1 {
+					values[i] = int64(v)
+				} else {
+					values[i] = int64(float64(v) * scale)
+				}
+			case metrics.KindFloat64:
+				values[i] = int64(math.Round(sample.Value.Float64() * scale))
+			case metrics.KindFloat64Histogram:
+				h := sample.Value.Float64Histogram()
+				delta := make([]uint64, len(h.Counts))
+				for j, c := range h.Counts {
+					delta[j] = c
+					if prev := previous[s]; len(prev) == len(h.Counts) {
+						delta[j] -= prev[j]
+					}
+				}
+				previous[s] = append(previous[s][:0], h.Counts...)
+
+				for j, q := range RuntimeMetricQuantiles {
+					if v, ok := histogramQuantile(delta, h.Buckets, q.Quantile); ok {
+						values[i+j] = int64(math.Round(v * scale))
+					}
+				}
+			}
+		}
+	})
+
+	return ret
+}
+
+// histogramQuantile estimates quantile q of a runtime/metrics
+// histogram as the upper boundary of the bucket it falls into (or the
+// lower boundary, should that be infinite).  It returns false if the
+// histogram is empty.
+func histogramQuantile(counts []uint64, buckets []float64, q float64) (float64, bool) {
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0, false
+	}
+
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var cum uint64
+	for i, c := range counts {
+		cum += c
+		if cum < rank {
+			continue
+		}
+		if upper := buckets[i+1]; !math.IsInf(upper, 0) {
+			return upper, true
+		}
+		if lower := buckets[i]; !math.IsInf(lower, 0) {
+			return lower, true
+		}
+		return 0, false
+	}
+
+	return 0, false
+}
+
+// Close the metric source and will stop reporting these runtime stats
+// to the reporter. Implements the io.Closer interface.
+func (g *RuntimeMetrics) Close() error {
+	g.reporter.Untrack(g.metrics...)
+	return nil
+}
@@ -0,0 +1,93 @@
+package signalfx
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestRuntimeMetrics(t *testing.T) {
+	Convey("Testing RuntimeMetrics", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`"OK"`))
+		}))
+		defer ts.Close()
+
+		config := NewConfig()
+		config.URL = ts.URL
+
+		reporter := NewReporter(config, nil)
+		So(reporter, ShouldNotBeNil)
+
+		Convey("names should be converted", func() {
+			name, scale := runtimeMetricName("/gc/heap/allocs:bytes")
+			So(name, ShouldEqual, "go-runtime-gc-heap-allocs-bytes")
+			So(scale, ShouldEqual, 1)
+
+			name, scale = runtimeMetricName("/cpu/classes/gc/total:cpu-seconds")
+			So(name, ShouldEqual, "go-runtime-cpu-classes-gc-total-cpu-ns")
+			So(scale, ShouldEqual, 1e9)
+		})
+
+		Convey("quantiles should be estimated", func() {
+			buckets := []float64{math.Inf(-1), 1, 2, 3, math.Inf(1)}
+
+			_, ok := histogramQuantile([]uint64{0, 0, 0, 0}, buckets, 0.5)
+			So(ok, ShouldBeFalse)
+
+			counts := []uint64{0, 5, 4, 1}
+			v, ok := histogramQuantile(counts, buckets, 0.5)
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, 2)
+			v, _ = histogramQuantile(counts, buckets, 0.9)
+			So(v, ShouldEqual, 3)
+			// the last bucket is unbounded above
+			v, _ = histogramQuantile(counts, buckets, 1)
+			So(v, ShouldEqual, 3)
+		})
+
+		Convey("metrics should be filtered and reported", func() {
+			rm := NewRuntimeMetrics(
+				reporter,
+				map[string]string{"system": "test"},
+				[]string{"/gc/*:*", "/sched/goroutines:goroutines", "/gc/pauses:seconds"},
+				[]string{"/gc/heap/*"},
+			)
+			runtime.GC()
+
+			datapoints, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(datapoints, ShouldNotBeEmpty)
+
+			seen := map[string]bool{}
+			for _, dp := range datapoints {
+				seen[dp.Metric] = true
+				So(dp.Dimensions["system"], ShouldEqual, "test")
+				So(strings.HasPrefix(dp.Metric, "go-runtime-gc-heap-"), ShouldBeFalse)
+				So(dp.Value, ShouldBeGreaterThanOrEqualTo, 0)
+
+				switch dp.Metric {
+				case "go-runtime-sched-goroutines-goroutines":
+					So(dp.Type, ShouldEqual, GaugeType)
+					So(dp.Value, ShouldBeGreaterThan, 0)
+				case "go-runtime-gc-pauses-ns":
+					So(dp.Type, ShouldEqual, GaugeType)
+					So(dp.Dimensions["quantile"], ShouldNotBeEmpty)
+				}
+			}
+			So(seen["go-runtime-sched-goroutines-goroutines"], ShouldBeTrue)
+			So(seen["go-runtime-gc-pauses-ns"], ShouldBeTrue)
+
+			So(rm.Close(), ShouldBeNil)
+			datapoints, err = reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(datapoints, ShouldBeEmpty)
+		})
+	})
+}
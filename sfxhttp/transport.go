@@ -0,0 +1,375 @@
+/*
+Package sfxhttp instruments outbound HTTP requests, reporting their
+latency, status codes and sizes to SignalFx through a signalfx.Reporter.
+
+A Transport wraps any http.RoundTripper:
+
+	tr := sfxhttp.NewTransport(reporter, nil, map[string]string{"service": "foo"})
+	client := &http.Client{Transport: tr}
+
+Because a Transport only produces datapoints when asked to, it may also be
+used as the transport of the signalfx.Client itself, measuring the library's
+own ingest calls:
+
+	tr := sfxhttp.NewTransport(nil, config.Transport(), nil)
+	config.RoundTripper = tr
+	reporter := signalfx.NewReporter(config, nil)
+	reporter.AddDataPointsCallback(tr.DataPoints)
+*/
+package sfxhttp
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zvelo.io/go-signalfx"
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+const (
+	// MetricRequests is the counter of requests made
+	MetricRequests = "http-client-requests"
+	// MetricRequestBytes is the counter of request body bytes sent
+	MetricRequestBytes = "http-client-request-bytes"
+	// MetricResponseBytes is the counter of response body bytes read
+	MetricResponseBytes = "http-client-response-bytes"
+	// MetricDuration is the bucket of round trip times, in microseconds,
+	// up until the response headers were received
+	MetricDuration = "http-client-duration-us"
+	// MetricDNS is the bucket of DNS lookup times, in microseconds
+	MetricDNS = "http-client-dns-us"
+	// MetricConnect is the bucket of TCP connect times, in microseconds
+	MetricConnect = "http-client-connect-us"
+	// MetricTLS is the bucket of TLS handshake times, in microseconds
+	MetricTLS = "http-client-tls-us"
+	// MetricTTFB is the bucket of times to the first response byte, in
+	// microseconds
+	MetricTTFB = "http-client-ttfb-us"
+
+	// StatusClassError is the status class reported for requests which
+	// failed without a response
+	StatusClassError = "error"
+
+	// DefaultMaxHosts is the default value of Transport.MaxHosts
+	DefaultMaxHosts = 100
+	// OtherHost is the host dimension of requests to hosts beyond
+	// Transport.MaxHosts
+	OtherHost = "other"
+)
+
+// statsKey identifies a single set of metrics
+type statsKey struct {
+	host, method, class string
+}
+
+// stats holds the metrics for a single statsKey
+type stats struct {
+	requests, requestBytes, responseBytes uint64
+	duration, dns, connect, tls, ttfb     *signalfx.Bucket
+}
+
+func newStats(dims map[string]string) *stats {
+	return &stats{
+		duration: signalfx.NewBucket(MetricDuration, dims),
+		dns:      signalfx.NewBucket(MetricDNS, dims),
+		connect:  signalfx.NewBucket(MetricConnect, dims),
+		tls:      signalfx.NewBucket(MetricTLS, dims),
+		ttfb:     signalfx.NewBucket(MetricTTFB, dims),
+	}
+}
+
+// A Transport is an http.RoundTripper which records metrics for each
+// request before handing it off to its base RoundTripper.  Each metric
+// has the dimensions "host", "method" and "status_class" (e.g. "2xx",
+// or "error" if no response was received) in addition to those the
+// Transport was created with.  Metrics are kept for at most MaxHosts
+// hosts, so that a client of arbitrary URLs does not grow without
+// bound; requests to any other host are reported with the host
+// OtherHost.  All operations on a Transport are goroutine safe.
+type Transport struct {
+	// Trace enables httptrace-based phase timings (DNS, connect, TLS
+	// and time to first byte).  It must not be changed once the
+	// Transport is in use.
+	Trace bool
+
+	// MaxHosts is the number of hosts for which metrics are kept, by
+	// default DefaultMaxHosts.  It must not be changed once the
+	// Transport is in use.
+	MaxHosts int
+
+	base       http.RoundTripper
+	dimensions map[string]string
+	mu         sync.Mutex
+	stats      map[statsKey]*stats
+	hosts      map[string]struct{}
+
+	// clones maps the in-flight requests which were traced to the
+	// clones passed to base, so that they may be canceled
+	clones map[*http.Request]*http.Request
+}
+
+// NewTransport returns a new Transport wrapping base; if base is nil,
+// http.DefaultTransport is used.  If reporter is not nil, the
+// Transport's DataPoints are added to it as a DataPointCallback.
+// dimensions are copied.
+func NewTransport(
+	reporter *signalfx.Reporter,
+	base http.RoundTripper,
+	dimensions map[string]string,
+) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ret := &Transport{
+		base:       base,
+		MaxHosts:   DefaultMaxHosts,
+		dimensions: sfxproto.Dimensions(dimensions).Clone(),
+		stats:      map[statsKey]*stats{},
+		hosts:      map[string]struct{}{},
+		clones:     map[*http.Request]*http.Request{},
+	}
+
+	if reporter != nil {
+		reporter.AddDataPointsCallback(ret.DataPoints)
+	}
+
+	return ret
+}
+
+func (t *Transport) statsFor(key statsKey) *stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.hosts[key.host]; !ok {
+		if len(t.hosts) >= t.MaxHosts {
+			key.host = OtherHost
+		} else {
+			t.hosts[key.host] = struct{}{}
+		}
+	}
+
+	s, ok := t.stats[key]
+	if !ok {
+		s = newStats(sfxproto.Dimensions(t.dimensions).Append(map[string]string{
+			"host":         key.host,
+			"method":       key.method,
+			"status_class": key.class,
+		}))
+		t.stats[key] = s
+	}
+
+	return s
+}
+
+// phases records the httptrace timings of a single request.  The
+// trace hooks may be called from other goroutines (e.g. when a dial
+// completes in the background), so all access is guarded by mu.
+type phases struct {
+	mu                      sync.Mutex
+	dns, connect, tls, ttfb time.Duration
+	dnsStart, connectStart  time.Time
+	tlsStart                time.Time
+}
+
+// mark sets t to the current time
+func (p *phases) mark(t *time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	*t = time.Now()
+}
+
+// done sets d to the time elapsed since *start, if it has been marked
+func (p *phases) done(start *time.Time, d *time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !start.IsZero() {
+		*d = time.Since(*start)
+	}
+}
+
+func (p *phases) clientTrace(start time.Time) *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { p.mark(&p.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { p.done(&p.dnsStart, &p.dns) },
+		ConnectStart:         func(string, string) { p.mark(&p.connectStart) },
+		ConnectDone:          func(string, string, error) { p.done(&p.connectStart, &p.connect) },
+		TLSHandshakeStart:    func() { p.mark(&p.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { p.done(&p.tlsStart, &p.tls) },
+		GotFirstResponseByte: func() { p.done(&start, &p.ttfb) },
+	}
+}
+
+// record adds the recorded timings to s
+func (p *phases) record(s *stats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.dns > 0 {
+		s.dns.Add(microseconds(p.dns))
+	}
+	if p.connect > 0 {
+		s.connect.Add(microseconds(p.connect))
+	}
+	if p.tls > 0 {
+		s.tls.Add(microseconds(p.tls))
+	}
+	if p.ttfb > 0 {
+		s.ttfb.Add(microseconds(p.ttfb))
+	}
+}
+
+func microseconds(d time.Duration) int64 {
+	return int64(d / time.Microsecond)
+}
+
+// statusClass returns e.g. "2xx" for a status code of 200
+func statusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+
+	var (
+		p    *phases
+		done = func() {}
+	)
+	if t.Trace {
+		p = &phases{}
+		orig := req
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), p.clientTrace(start)))
+		t.setClone(orig, req)
+		done = func() { t.setClone(orig, nil) }
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	key := statsKey{host: req.URL.Host, method: req.Method, class: StatusClassError}
+	if err == nil {
+		key.class = statusClass(resp.StatusCode)
+	}
+
+	s := t.statsFor(key)
+	atomic.AddUint64(&s.requests, 1)
+	if req.ContentLength > 0 {
+		atomic.AddUint64(&s.requestBytes, uint64(req.ContentLength))
+	}
+	s.duration.Add(microseconds(elapsed))
+
+	if p != nil {
+		p.record(s)
+	}
+
+	if err == nil && resp.Body != nil {
+		resp.Body = &countingBody{ReadCloser: resp.Body, stats: s, done: done}
+	} else {
+		done()
+	}
+
+	return resp, err
+}
+
+// setClone records that clone was passed to base in place of req, or
+// forgets req if clone is nil
+func (t *Transport) setClone(req, clone *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if clone == nil {
+		delete(t.clones, req)
+	} else {
+		t.clones[req] = clone
+	}
+}
+
+// CancelRequest cancels an in-flight request, including the reading of
+// its response body, if the base RoundTripper supports it.
+func (t *Transport) CancelRequest(req *http.Request) {
+	t.mu.Lock()
+	if clone, ok := t.clones[req]; ok {
+		req = clone
+	}
+	t.mu.Unlock()
+
+	if tr, ok := t.base.(interface {
+		CancelRequest(*http.Request)
+	}); ok {
+		tr.CancelRequest(req)
+	}
+}
+
+// DataPoints returns the DataPoints accumulated since the last call to
+// DataPoints, resetting them.  It is normally passed to
+// Reporter.AddDataPointsCallback.
+func (t *Transport) DataPoints() []signalfx.DataPoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var ret []signalfx.DataPoint
+	timestamp := time.Now()
+
+	for _, s := range t.stats {
+		dims := s.duration.Dimensions()
+
+		for metric, value := range map[string]*uint64{
+			MetricRequests:      &s.requests,
+			MetricRequestBytes:  &s.requestBytes,
+			MetricResponseBytes: &s.responseBytes,
+		} {
+			v := atomic.SwapUint64(value, 0)
+			if v == 0 {
+				continue
+			}
+			ret = append(ret, signalfx.DataPoint{
+				Metric:     metric,
+				Type:       signalfx.CounterType,
+				Value:      int64(v),
+				Timestamp:  timestamp,
+				Dimensions: dims,
+			})
+		}
+
+		ret = append(ret, s.duration.DataPoints()...)
+		if t.Trace {
+			ret = append(ret, s.dns.DataPoints()...)
+			ret = append(ret, s.connect.DataPoints()...)
+			ret = append(ret, s.tls.DataPoints()...)
+			ret = append(ret, s.ttfb.DataPoints()...)
+		}
+	}
+
+	return ret
+}
+
+// countingBody counts the bytes read from a response body, and calls
+// done once closed
+type countingBody struct {
+	io.ReadCloser
+	stats *stats
+	done  func()
+	once  sync.Once
+}
+
+func (b *countingBody) Close() error {
+	b.once.Do(b.done)
+	return b.ReadCloser.Close()
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		atomic.AddUint64(&b.stats.responseBytes, uint64(n))
+	}
+	return n, err
+}
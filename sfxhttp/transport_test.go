@@ -0,0 +1,195 @@
+package sfxhttp
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+	"zvelo.io/go-signalfx"
+)
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("failed")
+}
+
+// blockingTransport blocks each request until it is canceled
+type blockingTransport struct {
+	started  chan *http.Request
+	canceled chan *http.Request
+}
+
+func (b blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b.started <- req
+	for r := range b.canceled {
+		if r == req {
+			return nil, errors.New("canceled")
+		}
+	}
+	return nil, errors.New("closed")
+}
+
+func (b blockingTransport) CancelRequest(req *http.Request) {
+	b.canceled <- req
+}
+
+func find(dps []signalfx.DataPoint, metric string, dims map[string]string) *signalfx.DataPoint {
+	for i, dp := range dps {
+		if dp.Metric != metric {
+			continue
+		}
+		match := true
+		for k, v := range dims {
+			if dp.Dimensions[k] != v {
+				match = false
+			}
+		}
+		if match {
+			return &dps[i]
+		}
+	}
+	return nil
+}
+
+func TestTransport(t *testing.T) {
+	Convey("Testing Transport", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/missing" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+			w.Write([]byte("hello"))
+		}))
+		defer ts.Close()
+		u, _ := url.Parse(ts.URL)
+
+		tr := NewTransport(nil, nil, map[string]string{"service": "test"})
+		tr.Trace = true
+		client := &http.Client{Transport: tr}
+
+		resp, err := client.Post(ts.URL, "text/plain", strings.NewReader("abc"))
+		So(err, ShouldBeNil)
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, "hello")
+		So(resp.Body.Close(), ShouldBeNil)
+
+		resp, err = client.Get(ts.URL + "/missing")
+		So(err, ShouldBeNil)
+		So(resp.Body.Close(), ShouldBeNil)
+
+		dps := tr.DataPoints()
+		ok := map[string]string{"host": u.Host, "method": "POST", "status_class": "2xx", "service": "test"}
+
+		dp := find(dps, MetricRequests, ok)
+		So(dp, ShouldNotBeNil)
+		So(dp.Type, ShouldEqual, signalfx.CounterType)
+		So(dp.Value, ShouldEqual, 1)
+
+		dp = find(dps, MetricRequestBytes, ok)
+		So(dp, ShouldNotBeNil)
+		So(dp.Value, ShouldEqual, 3)
+
+		dp = find(dps, MetricResponseBytes, ok)
+		So(dp, ShouldNotBeNil)
+		So(dp.Value, ShouldEqual, 5)
+
+		dp = find(dps, MetricDuration, map[string]string{"method": "POST", "rollup": "count"})
+		So(dp, ShouldNotBeNil)
+		So(dp.Value, ShouldEqual, 1)
+
+		dp = find(dps, MetricConnect, map[string]string{"method": "POST", "rollup": "count"})
+		So(dp, ShouldNotBeNil)
+		So(dp.Value, ShouldEqual, 1)
+
+		dp = find(dps, MetricTTFB, map[string]string{"method": "POST", "rollup": "count"})
+		So(dp, ShouldNotBeNil)
+		So(dp.Value, ShouldEqual, 1)
+
+		dp = find(dps, MetricRequests, map[string]string{"method": "GET", "status_class": "4xx"})
+		So(dp, ShouldNotBeNil)
+		So(dp.Value, ShouldEqual, 1)
+
+		// the counters are reset once reported
+		So(find(tr.DataPoints(), MetricRequests, nil), ShouldBeNil)
+		// and closed bodies are forgotten
+		So(tr.clones, ShouldBeEmpty)
+
+		Convey("failed requests should be reported as errors", func() {
+			tr := NewTransport(nil, failingTransport{}, nil)
+			client := &http.Client{Transport: tr}
+			_, err := client.Get(ts.URL)
+			So(err, ShouldNotBeNil)
+
+			dp := find(tr.DataPoints(), MetricRequests, map[string]string{"status_class": StatusClassError})
+			So(dp, ShouldNotBeNil)
+			So(dp.Value, ShouldEqual, 1)
+		})
+
+		Convey("traced requests should be canceled", func() {
+			base := blockingTransport{
+				started:  make(chan *http.Request),
+				canceled: make(chan *http.Request, 1),
+			}
+			tr := NewTransport(nil, base, nil)
+			tr.Trace = true
+
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			errs := make(chan error)
+			go func() {
+				_, err := tr.RoundTrip(req)
+				errs <- err
+			}()
+
+			So(<-base.started, ShouldNotEqual, req)
+			tr.CancelRequest(req)
+			So(<-errs, ShouldNotBeNil)
+			So(tr.clones, ShouldBeEmpty)
+		})
+
+		Convey("the number of hosts should be bounded", func() {
+			tr := NewTransport(nil, failingTransport{}, nil)
+			tr.MaxHosts = 1
+			client := &http.Client{Transport: tr}
+			for _, host := range []string{"a.example", "b.example", "c.example", "a.example"} {
+				client.Get("http://" + host + "/")
+			}
+
+			dps := tr.DataPoints()
+			So(find(dps, MetricRequests, map[string]string{"host": "a.example"}).Value, ShouldEqual, 2)
+			So(find(dps, MetricRequests, map[string]string{"host": OtherHost}).Value, ShouldEqual, 2)
+			So(find(dps, MetricRequests, map[string]string{"host": "b.example"}), ShouldBeNil)
+		})
+
+		Convey("a reporter should measure its own ingest calls", func() {
+			ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`"OK"`))
+			}))
+			defer ingest.Close()
+
+			config := signalfx.NewConfig()
+			config.URL = ingest.URL
+			tr := NewTransport(nil, config.Transport(), nil)
+			config.RoundTripper = tr
+
+			reporter := signalfx.NewReporter(config, nil)
+			reporter.AddDataPointsCallback(tr.DataPoints)
+			reporter.Track(signalfx.NewGauge("gauge", nil, 1))
+
+			_, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+
+			dps, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			dp := find(dps, MetricRequests, map[string]string{"status_class": "2xx"})
+			So(dp, ShouldNotBeNil)
+			So(dp.Value, ShouldEqual, 1)
+		})
+	})
+}
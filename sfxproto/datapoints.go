@@ -2,6 +2,9 @@ package sfxproto
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/golang/protobuf/proto"
@@ -13,17 +16,23 @@ var (
 	ErrMarshalNoData = fmt.Errorf("no data to marshal")
 )
 
-// DataPoints is a set of DataPoint objects
+// DataPoints is a set of DataPoint objects. It preserves the order in
+// which they were added, so that List and Marshal are deterministic.
 type DataPoints struct {
-	data map[*DataPoint]interface{}
-	lock sync.Mutex
+	data  map[*DataPoint]interface{}
+	order []*DataPoint
+	lock  sync.Mutex
+
+	// sorted is set by Sort, so that List sorts dimensions too
+	sorted bool
 }
 
 // NewDataPoints creates a new DataPoints object with expected size of
 // l
 func NewDataPoints(l int) *DataPoints {
 	return &DataPoints{
-		data: make(map[*DataPoint]interface{}, l),
+		data:  make(map[*DataPoint]interface{}, l),
+		order: make([]*DataPoint, 0, l),
 	}
 }
 
@@ -35,15 +44,26 @@ func (ps *DataPoints) Len() int {
 	return len(ps.data)
 }
 
-// List returns a slice of copies of each DataPoint
+// List returns a slice of copies of each DataPoint, in order.  Once
+// ps has been sorted, the dimensions of each copy are sorted by key.
 func (ps *DataPoints) List() []*DataPoint {
 	ret := make([]*DataPoint, 0, ps.Len())
 
 	ps.lock.Lock()
 	defer ps.lock.Unlock()
 
-	for p := range ps.data {
-		ret = append(ret, p.Clone())
+	for _, p := range ps.order {
+		p = p.Clone()
+		if ps.sorted {
+			sort.SliceStable(p.Dimensions, func(i, j int) bool {
+				a, b := p.Dimensions[i], p.Dimensions[j]
+				if a.GetKey() != b.GetKey() {
+					return a.GetKey() < b.GetKey()
+				}
+				return a.GetValue() < b.GetValue()
+			})
+		}
+		ret = append(ret, p)
 	}
 
 	return ret
@@ -60,13 +80,17 @@ func (ps *DataPoints) Marshal() ([]byte, error) {
 	})
 }
 
-// Add a new DataPoint to the list
+// Add a new DataPoint to the end of the list. Adding a DataPoint which
+// is already in the list has no effect.
 func (ps *DataPoints) Add(dataPoint *DataPoint) *DataPoints {
 	if dataPoint != nil && dataPoint.Metric != nil && len(*dataPoint.Metric) > 0 {
 		ps.lock.Lock()
 		defer ps.lock.Unlock()
 
-		ps.data[dataPoint] = nil
+		if _, ok := ps.data[dataPoint]; !ok {
+			ps.data[dataPoint] = nil
+			ps.order = append(ps.order, dataPoint)
+		}
 	}
 
 	return ps
@@ -77,7 +101,7 @@ func (ps *DataPoints) Append(val *DataPoints) *DataPoints {
 	val.lock.Lock()
 	defer val.lock.Unlock()
 
-	for p := range val.data {
+	for _, p := range val.order {
 		ps.Add(p)
 	}
 
@@ -89,9 +113,136 @@ func (ps *DataPoints) Remove(vals ...*DataPoint) *DataPoints {
 	ps.lock.Lock()
 	defer ps.lock.Unlock()
 
+	removed := false
 	for _, val := range vals {
-		delete(ps.data, val)
+		if _, ok := ps.data[val]; ok {
+			delete(ps.data, val)
+			removed = true
+		}
+	}
+
+	if removed {
+		order := ps.order[:0]
+		for _, p := range ps.order {
+			if _, ok := ps.data[p]; ok {
+				order = append(order, p)
+			}
+		}
+		ps.order = order
 	}
 
 	return ps
 }
+
+// seriesKey returns the metric name and the sorted dimensions of p, which
+// identify its time series.  Keys and values are quoted, so that those
+// containing separators cannot make distinct series share a key.
+func seriesKey(p *DataPoint) (string, string) {
+	pairs := make([]string, len(p.Dimensions))
+	for i, dim := range p.Dimensions {
+		pairs[i] = strconv.Quote(dim.GetKey()) + "=" + strconv.Quote(dim.GetValue())
+	}
+	sort.Strings(pairs)
+	return p.GetMetric(), strings.Join(pairs, ",")
+}
+
+// Sort stably sorts the DataPoint objects by metric name and then by
+// dimensions, and makes List and Marshal sort the dimensions of each by
+// key, so that the marshaled payload is reproducible.  The DataPoint
+// objects themselves are not modified.
+func (ps *DataPoints) Sort() *DataPoints {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	ps.sorted = true
+
+	type keyed struct {
+		metric, dims string
+		p            *DataPoint
+	}
+	keys := make([]keyed, len(ps.order))
+	for i, p := range ps.order {
+		keys[i].metric, keys[i].dims = seriesKey(p)
+		keys[i].p = p
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].metric != keys[j].metric {
+			return keys[i].metric < keys[j].metric
+		}
+		return keys[i].dims < keys[j].dims
+	})
+
+	for i, k := range keys {
+		ps.order[i] = k.p
+	}
+
+	return ps
+}
+
+// sum adds the value of p to that of into, which must be a copy
+func sum(into, p *DataPoint) {
+	a, b := into.GetValue(), p.GetValue()
+	if a == nil || b == nil {
+		return
+	}
+
+	switch {
+	case a.IntValue != nil && b.IntValue != nil:
+		into.Value = &Datum{IntValue: proto.Int64(a.GetIntValue() + b.GetIntValue())}
+	case (a.IntValue != nil || a.DoubleValue != nil) && (b.IntValue != nil || b.DoubleValue != nil):
+		into.Value = &Datum{DoubleValue: proto.Float64(
+			float64(a.GetIntValue()) + a.GetDoubleValue() + float64(b.GetIntValue()) + b.GetDoubleValue(),
+		)}
+	}
+
+	if p.GetTimestamp() > into.GetTimestamp() {
+		into.Timestamp = p.Timestamp
+	}
+}
+
+// Coalesce returns a new DataPoints in which the DataPoint objects of the
+// same time series (i.e. with the same metric name, type and dimensions)
+// are merged into one: the values of counters are summed, while for every
+// other type the value with the latest timestamp (or, if equal, the one
+// added last) is kept. Each series keeps the position of its first
+// DataPoint. DataPoint objects which are merged are copied, so ps is not
+// modified.
+func (ps *DataPoints) Coalesce() *DataPoints {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	ret := NewDataPoints(len(ps.order))
+	index := make(map[string]int, len(ps.order))
+	copied := make(map[int]bool)
+
+	for _, p := range ps.order {
+		metric, dims := seriesKey(p)
+		key := fmt.Sprintf("%s\x00%d\x00%s", metric, p.GetMetricType(), dims)
+
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(ret.order)
+			ret.order = append(ret.order, p)
+			continue
+		}
+
+		prev := ret.order[i]
+		if p.GetMetricType() == MetricType_COUNTER {
+			if !copied[i] {
+				prev = prev.Clone()
+				ret.order[i] = prev
+				copied[i] = true
+			}
+			sum(prev, p)
+		} else if p.GetTimestamp() >= prev.GetTimestamp() {
+			ret.order[i] = p
+		}
+	}
+
+	for _, p := range ret.order {
+		ret.data[p] = nil
+	}
+
+	return ret
+}
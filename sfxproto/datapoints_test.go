@@ -111,6 +111,123 @@ func TestDataPoints(t *testing.T) {
 
 			So(ps.Remove(p1, nil), ShouldEqual, ps)
 			So(ps.Len(), ShouldEqual, 0)
+			So(ps.List(), ShouldBeEmpty)
+		})
+
+		dp := func(metric string, typ MetricType, value, timestamp int64, dims Dimensions) *DataPoint {
+			return &DataPoint{
+				Metric:     proto.String(metric),
+				Value:      &Datum{IntValue: proto.Int64(value)},
+				MetricType: typ.Enum(),
+				Timestamp:  proto.Int64(timestamp),
+				Dimensions: dims.List(),
+			}
+		}
+
+		metrics := func(ps *DataPoints) []string {
+			var ret []string
+			for _, p := range ps.List() {
+				ret = append(ret, p.GetMetric())
+			}
+			return ret
+		}
+
+		Convey("order should be preserved", func() {
+			ps := NewDataPoints(0)
+			for _, m := range []string{"c", "a", "b", "e", "d"} {
+				ps.Add(dp(m, MetricType_GAUGE, 1, 0, nil))
+			}
+			So(metrics(ps), ShouldResemble, []string{"c", "a", "b", "e", "d"})
+
+			// re-adding has no effect
+			p := ps.order[1]
+			ps.Add(p)
+			So(ps.Len(), ShouldEqual, 5)
+
+			ps.Remove(p)
+			So(metrics(ps), ShouldResemble, []string{"c", "b", "e", "d"})
+
+			data0, err := ps.Marshal()
+			So(err, ShouldBeNil)
+			data1, err := ps.Marshal()
+			So(err, ShouldBeNil)
+			So(data0, ShouldResemble, data1)
+		})
+
+		Convey("Sort should work", func() {
+			ps := NewDataPoints(0)
+			ps.Add(dp("b", MetricType_GAUGE, 1, 0, Dimensions{"x": "2"}))
+			ps.Add(dp("a", MetricType_GAUGE, 2, 0, nil))
+			ps.Add(dp("b", MetricType_GAUGE, 3, 0, Dimensions{"x": "1"}))
+			ps.Add(dp("a", MetricType_GAUGE, 4, 0, nil))
+
+			So(ps.Sort(), ShouldEqual, ps)
+			var values []int64
+			for _, p := range ps.List() {
+				values = append(values, p.GetValue().GetIntValue())
+			}
+			So(values, ShouldResemble, []int64{2, 4, 3, 1})
+
+			// dimensions are sorted too, without modifying the
+			// DataPoint objects
+			p := dp("c", MetricType_GAUGE, 5, 0, nil)
+			p.Dimensions = []*Dimension{
+				{Key: proto.String("z"), Value: proto.String("1")},
+				{Key: proto.String("a"), Value: proto.String("2")},
+			}
+			ps = NewDataPoints(0).Add(p)
+			data0, err := ps.Marshal()
+			So(err, ShouldBeNil)
+			ps.Sort()
+			So(p.Dimensions[0].GetKey(), ShouldEqual, "z")
+			So(ps.List()[0].Dimensions[0].GetKey(), ShouldEqual, "a")
+
+			p.Dimensions[0], p.Dimensions[1] = p.Dimensions[1], p.Dimensions[0]
+			data1, err := ps.Marshal()
+			So(err, ShouldBeNil)
+			data2, err := NewDataPoints(0).Add(p).Marshal()
+			So(err, ShouldBeNil)
+			So(data1, ShouldResemble, data2)
+			So(data1, ShouldNotResemble, data0)
+		})
+
+		Convey("separators should not merge distinct series", func() {
+			ps := NewDataPoints(0)
+			ps.Add(dp("c", MetricType_COUNTER, 1, 0, Dimensions{"a": "1,b=2"}))
+			ps.Add(dp("c", MetricType_COUNTER, 1, 0, Dimensions{"a": "1", "b": "2"}))
+			So(ps.Coalesce().Len(), ShouldEqual, 2)
+		})
+
+		Convey("Coalesce should work", func() {
+			ps := NewDataPoints(0)
+			c0 := dp("c", MetricType_COUNTER, 1, 10, Dimensions{"h": "a"})
+			ps.Add(c0)
+			ps.Add(dp("g", MetricType_GAUGE, 1, 20, nil))
+			ps.Add(dp("c", MetricType_COUNTER, 2, 30, Dimensions{"h": "a"}))
+			ps.Add(dp("c", MetricType_COUNTER, 4, 5, Dimensions{"h": "b"}))
+			ps.Add(dp("g", MetricType_GAUGE, 2, 40, nil))
+			ps.Add(dp("g", MetricType_GAUGE, 3, 30, nil))
+			ps.Add(dp("cc", MetricType_CUMULATIVE_COUNTER, 7, 10, nil))
+			ps.Add(dp("cc", MetricType_CUMULATIVE_COUNTER, 9, 10, nil))
+			ps.Add(&DataPoint{
+				Metric:     proto.String("c"),
+				Value:      &Datum{DoubleValue: proto.Float64(0.5)},
+				MetricType: MetricType_COUNTER.Enum(),
+				Dimensions: Dimensions{"h": "b"}.List(),
+			})
+
+			cps := ps.Coalesce()
+			So(cps, ShouldNotEqual, ps)
+			So(ps.Len(), ShouldEqual, 9)
+			So(c0.GetValue().GetIntValue(), ShouldEqual, 1)
+
+			list := cps.List()
+			So(metrics(cps), ShouldResemble, []string{"c", "g", "c", "cc"})
+			So(list[0].GetValue().GetIntValue(), ShouldEqual, 3)
+			So(list[0].GetTimestamp(), ShouldEqual, 30)
+			So(list[1].GetValue().GetIntValue(), ShouldEqual, 2)
+			So(list[2].GetValue().GetDoubleValue(), ShouldEqual, 4.5)
+			So(list[3].GetValue().GetIntValue(), ShouldEqual, 9)
 		})
 	})
 }
@@ -0,0 +1,146 @@
+package sfxproto
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// MaxMetricLength is the longest metric name accepted by SignalFx
+	MaxMetricLength = 256
+
+	// MaxDimensionKeyLength is the longest dimension key accepted by
+	// SignalFx
+	MaxDimensionKeyLength = 128
+
+	// MaxDimensionValueLength is the longest dimension value accepted by
+	// SignalFx
+	MaxDimensionValueLength = 256
+
+	// MaxDimensions is the most dimensions SignalFx accepts on a single
+	// datapoint
+	MaxDimensions = 36
+)
+
+// gzipMagic are the first bytes of gzip compressed data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Decode decodes a DataPointUploadMessage. data may be the protobuf
+// encoded message itself, gzip compressed or not, or a complete HTTP
+// request carrying it, as captured from the wire.
+func Decode(data []byte) (*DataPointUploadMessage, error) {
+	for _, method := range []string{"POST ", "PUT "} {
+		if bytes.HasPrefix(data, []byte(method)) {
+			return decodeRequest(data)
+		}
+	}
+
+	if bytes.HasPrefix(data, gzipMagic) {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+
+		if data, err = ioutil.ReadAll(gz); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := &DataPointUploadMessage{}
+	if err := proto.Unmarshal(data, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// decodeRequest decodes the body of an HTTP request
+func decodeRequest(data []byte) (*DataPointUploadMessage, error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	defer req.Body.Close()
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if ct := req.Header.Get("Content-Type"); ct != "" && ct != "application/x-protobuf" {
+		return nil, fmt.Errorf("unsupported content type %q", ct)
+	}
+	return Decode(body)
+}
+
+// validDimensionKey returns whether key consists of letters, digits, _ and
+// -, starting with a letter
+func validDimensionKey(key string) bool {
+	for i, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '_' || r == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Problems returns the reasons SignalFx would reject p, if any
+func (p *DataPoint) Problems() []string {
+	var ret []string
+
+	metric := p.GetMetric()
+	switch {
+	case metric == "":
+		ret = append(ret, "empty metric name")
+	case len(metric) > MaxMetricLength:
+		ret = append(ret, fmt.Sprintf("metric name longer than %d", MaxMetricLength))
+	}
+
+	if _, ok := MetricType_name[int32(p.GetMetricType())]; !ok {
+		ret = append(ret, fmt.Sprintf("invalid metric type %d", p.GetMetricType()))
+	}
+
+	if v := p.GetValue(); v == nil || v.StrValue == nil && v.DoubleValue == nil && v.IntValue == nil {
+		ret = append(ret, "no value")
+	}
+
+	if len(p.Dimensions) > MaxDimensions {
+		ret = append(ret, fmt.Sprintf("more than %d dimensions", MaxDimensions))
+	}
+
+	seen := map[string]bool{}
+	for _, dim := range p.Dimensions {
+		key, value := dim.GetKey(), dim.GetValue()
+		switch {
+		case key == "":
+			ret = append(ret, "empty dimension key")
+		case len(key) > MaxDimensionKeyLength:
+			ret = append(ret, fmt.Sprintf("dimension key %q longer than %d", key, MaxDimensionKeyLength))
+		case !validDimensionKey(key):
+			ret = append(ret, fmt.Sprintf("invalid dimension key %q", key))
+		case len(key) > 3 && key[:3] == "sf_":
+			ret = append(ret, fmt.Sprintf("reserved dimension key %q", key))
+		case seen[key]:
+			ret = append(ret, fmt.Sprintf("duplicate dimension key %q", key))
+		}
+		seen[key] = true
+
+		switch {
+		case value == "":
+			ret = append(ret, fmt.Sprintf("empty value for dimension %q", key))
+		case len(value) > MaxDimensionValueLength:
+			ret = append(ret, fmt.Sprintf("value of dimension %q longer than %d", key, MaxDimensionValueLength))
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,92 @@
+package sfxproto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDecode(t *testing.T) {
+	Convey("Testing Decode", t, func() {
+		msg := &DataPointUploadMessage{Datapoints: []*DataPoint{{
+			Metric:     proto.String("requests"),
+			MetricType: MetricType_COUNTER.Enum(),
+			Value:      &Datum{IntValue: proto.Int64(3)},
+			Dimensions: Dimensions{"host": "a"}.List(),
+		}}}
+		data, err := proto.Marshal(msg)
+		So(err, ShouldBeNil)
+
+		Convey("raw messages should be decoded", func() {
+			got, err := Decode(data)
+			So(err, ShouldBeNil)
+			So(proto.Equal(got, msg), ShouldBeTrue)
+		})
+
+		Convey("gzip compressed messages should be decoded", func() {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			_, _ = gz.Write(data)
+			So(gz.Close(), ShouldBeNil)
+
+			got, err := Decode(buf.Bytes())
+			So(err, ShouldBeNil)
+			So(proto.Equal(got, msg), ShouldBeTrue)
+		})
+
+		Convey("HTTP requests should be decoded", func() {
+			req := fmt.Sprintf("POST /v2/datapoint HTTP/1.1\r\nHost: ingest.signalfx.com\r\n"+
+				"Content-Type: application/x-protobuf\r\nContent-Length: %d\r\n\r\n%s", len(data), data)
+			got, err := Decode([]byte(req))
+			So(err, ShouldBeNil)
+			So(proto.Equal(got, msg), ShouldBeTrue)
+
+			req = "POST /v2/datapoint HTTP/1.1\r\nHost: a\r\nContent-Type: application/json\r\nContent-Length: 2\r\n\r\n{}"
+			_, err = Decode([]byte(req))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("garbage should fail", func() {
+			_, err := Decode([]byte{0xff, 0xff, 0xff})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Testing DataPoint.Problems", t, func() {
+		dp := &DataPoint{
+			Metric:     proto.String("requests"),
+			MetricType: MetricType_GAUGE.Enum(),
+			Value:      &Datum{IntValue: proto.Int64(3)},
+			Dimensions: []*Dimension{
+				{Key: proto.String("host"), Value: proto.String("a")},
+				{Key: proto.String("http-method"), Value: proto.String("GET")},
+			},
+		}
+		So(dp.Problems(), ShouldBeEmpty)
+
+		bad := &DataPoint{
+			Metric:     proto.String(strings.Repeat("m", MaxMetricLength+1)),
+			MetricType: MetricType(7).Enum(),
+			Dimensions: []*Dimension{
+				{Key: proto.String("1st"), Value: proto.String("a")},
+				{Key: proto.String("sf_metric"), Value: proto.String("a")},
+				{Key: proto.String("host"), Value: proto.String("")},
+				{Key: proto.String("host"), Value: proto.String("a")},
+			},
+		}
+		So(bad.Problems(), ShouldResemble, []string{
+			"metric name longer than 256",
+			"invalid metric type 7",
+			"no value",
+			`invalid dimension key "1st"`,
+			`reserved dimension key "sf_metric"`,
+			`empty value for dimension "host"`,
+			`duplicate dimension key "host"`,
+		})
+	})
+}
@@ -0,0 +1,324 @@
+package signalfx
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheLineSize is the size to which shards are padded, so that no two
+// shards share a cache line
+const cacheLineSize = 64
+
+// A shardHint is the shard index used by whichever goroutine holds it.
+// Hints are kept in a sync.Pool, whose per-P caches mean that goroutines
+// running on the same P tend to get the same hint, and so write to the
+// same shard, while those on other Ps write to others.
+type shardHint struct {
+	index uint32
+}
+
+var (
+	nextShardHint uint32
+	shardHints    = sync.Pool{
+		New: func() interface{} {
+			return &shardHint{index: atomic.AddUint32(&nextShardHint, 1) - 1}
+		},
+	}
+)
+
+// shardMask returns the mask of the shard indexes of a new sharded
+// metric, which has as many shards as the smallest power of two no
+// lower than GOMAXPROCS
+func shardMask() uint32 {
+	n := 1
+	for n < runtime.GOMAXPROCS(0) {
+		n <<= 1
+	}
+	return uint32(n - 1)
+}
+
+// shardIndex returns the index of the shard to which the calling
+// goroutine should write
+func shardIndex(mask uint32) uint32 {
+	h := shardHints.Get().(*shardHint)
+	i := h.index & mask
+	shardHints.Put(h)
+	return i
+}
+
+type counterShard struct {
+	value uint64
+	_     [cacheLineSize - 8]byte
+}
+
+// A ShardedCounter is a Counter which spreads its increments over a
+// padded cell per shard, so that goroutines incrementing it on many CPUs
+// at once do not contend for a single cache line.  The shards are summed
+// by DataPoint, and PostReportHook resets it exactly as it does a
+// Counter, so increments are reported exactly once.  It is meant for
+// counters which are incremented very frequently from many goroutines;
+// otherwise, a Counter is both smaller and cheaper to report.
+type ShardedCounter struct {
+	metric     string
+	dimensions map[string]string
+	mask       uint32
+	shards     []counterShard
+}
+
+// NewShardedCounter returns a new ShardedCounter with the specified
+// parameters.  It does not copy the dimensions; client code should take
+// care not to modify them in a goroutine-unsafe manner.
+func NewShardedCounter(
+	metric string,
+	dimensions map[string]string,
+	value uint64,
+) *ShardedCounter {
+	mask := shardMask()
+	c := &ShardedCounter{
+		metric:     metric,
+		dimensions: dimensions,
+		mask:       mask,
+		shards:     make([]counterShard, mask+1),
+	}
+	c.shards[0].value = value
+	return c
+}
+
+// Inc increments a ShardedCounter's internal state.  It is
+// goroutine-safe.  Unlike Counter.Inc, it does not return the new
+// value, since that would require reading every shard; use Value.
+func (c *ShardedCounter) Inc(delta uint64) {
+	atomic.AddUint64(&c.shards[shardIndex(c.mask)].value, delta)
+}
+
+// Value returns the sum of a ShardedCounter's shards
+func (c *ShardedCounter) Value() uint64 {
+	var value uint64
+	for i := range c.shards {
+		value += atomic.LoadUint64(&c.shards[i].value)
+	}
+	return value
+}
+
+// DataPoint returns a DataPoint reflecting the ShardedCounter's
+// internal state.  It does not reset that state, leaving that to
+// PostReportHook.
+func (c *ShardedCounter) DataPoint() *DataPoint {
+	value := c.Value()
+	if value == 0 || value > math.MaxInt64 {
+		return nil
+	}
+	return &DataPoint{
+		Metric:     c.metric,
+		Timestamp:  time.Now(),
+		Type:       CounterType,
+		Dimensions: c.dimensions,
+		Value:      int64(value),
+	}
+}
+
+// PostReportHook resets a ShardedCounter's internal state by
+// subtracting the successfully-reported value, as Counter.PostReportHook
+// does.  The whole value is subtracted from the first shard, which may
+// therefore wrap around: only the sum of the shards is meaningful.
+// Calling PostReportHook with a negative value will result in a panic.
+func (c *ShardedCounter) PostReportHook(v int64) {
+	if v < 0 {
+		panic("negative counter should be impossible")
+	}
+	vv := uint64(v)
+	atomic.AddUint64(&c.shards[0].value, ^(vv - 1))
+}
+
+// A bucketShard is padded with a whole cache line, so that the
+// statistics of neighbouring shards are never on the same one
+type bucketShard struct {
+	mu    sync.Mutex
+	stats bucketStats
+	_     [cacheLineSize]byte
+}
+
+// A ShardedBucket is a Bucket which spreads the values added to it over
+// a padded set of statistics per shard, each with its own lock, so that
+// goroutines adding to it on many CPUs at once do not contend for the
+// same lock or cache lines.  The shards are merged, and reset, by
+// DataPoints; every value added is reported exactly once.  All
+// operations on ShardedBuckets are goroutine safe.
+type ShardedBucket struct {
+	// config holds the metric name, dimensions and disabled metrics,
+	// and renders the merged statistics as DataPoints
+	config *Bucket
+	mask   uint32
+	shards []bucketShard
+}
+
+// NewShardedBucket creates a new ShardedBucket. Because the passed in
+// dimensions can not be locked by this method, it is important that the
+// caller ensures its state does not change for the duration of the
+// operation.  It is configured by the first of options, if any, as
+// NewBucket is.
+func NewShardedBucket(metric string, dimensions map[string]string, options ...BucketOptions) *ShardedBucket {
+	mask := shardMask()
+	b := &ShardedBucket{
+		config: NewBucket(metric, dimensions, options...),
+		mask:   mask,
+		shards: make([]bucketShard, mask+1),
+	}
+	for i := range b.shards {
+		b.shards[i].stats = newBucketStats()
+	}
+	return b
+}
+
+// Metric returns the metric name of the ShardedBucket
+func (b *ShardedBucket) Metric() string {
+	return b.config.Metric()
+}
+
+// SetMetric sets the metric name of the ShardedBucket
+func (b *ShardedBucket) SetMetric(name string) {
+	b.config.SetMetric(name)
+}
+
+// Dimensions returns a copy of the dimensions of the ShardedBucket.
+// Changes are not reflected inside the ShardedBucket itself.
+func (b *ShardedBucket) Dimensions() map[string]string {
+	return b.config.Dimensions()
+}
+
+// SetDimension adds or overwrites the dimension at key with value. If
+// the key or value is empty, no changes are made
+func (b *ShardedBucket) SetDimension(key, value string) {
+	b.config.SetDimension(key, value)
+}
+
+// SetDimensions adds or overwrites multiple dimensions, as
+// Bucket.SetDimensions does.
+func (b *ShardedBucket) SetDimensions(dims map[string]string) {
+	b.config.SetDimensions(dims)
+}
+
+// RemoveDimension removes one or more dimensions with the given keys
+func (b *ShardedBucket) RemoveDimension(keys ...string) {
+	b.config.RemoveDimension(keys...)
+}
+
+// Disable disables the given metrics for this bucket.
+// They will be collected, but not reported.
+func (b *ShardedBucket) Disable(metrics ...int) {
+	b.config.Disable(metrics...)
+}
+
+// Enable enables the given metrics for this bucket, such as those
+// which are disabled by default.
+func (b *ShardedBucket) Enable(metrics ...int) {
+	b.config.Enable(metrics...)
+}
+
+// Add an item to the ShardedBucket, later reporting the result in the
+// next report cycle.
+func (b *ShardedBucket) Add(val int64) {
+	s := &b.shards[shardIndex(b.mask)]
+	s.mu.Lock()
+	s.stats.add(val)
+	s.mu.Unlock()
+}
+
+// stats returns the merged statistics of every shard, resetting them
+// if reset is true
+func (b *ShardedBucket) stats(reset bool) bucketStats {
+	ret := newBucketStats()
+	for i := range b.shards {
+		s := &b.shards[i]
+		s.mu.Lock()
+		ret.merge(s.stats)
+		if reset {
+			s.stats = newBucketStats()
+		}
+		s.mu.Unlock()
+	}
+	return ret
+}
+
+// Count returns the number of items added to the ShardedBucket
+func (b *ShardedBucket) Count() uint64 {
+	stats := b.stats(false)
+	return stats.count
+}
+
+// Min returns the lowest item added to the ShardedBucket
+func (b *ShardedBucket) Min() int64 {
+	stats := b.stats(false)
+	return stats.min
+}
+
+// Max returns the highest item added to the ShardedBucket
+func (b *ShardedBucket) Max() int64 {
+	stats := b.stats(false)
+	return stats.max
+}
+
+// Sum returns the sum of all items added to the ShardedBucket.  If it
+// has overflowed, it is saturated at math.MaxInt64 or math.MinInt64.
+func (b *ShardedBucket) Sum() int64 {
+	stats := b.stats(false)
+	return stats.sum
+}
+
+// Overflowed returns whether the sum of the items added to the
+// ShardedBucket has overflowed an int64
+func (b *ShardedBucket) Overflowed() bool {
+	stats := b.stats(false)
+	return stats.overflow
+}
+
+// SumOfSquares returns the sum of the square of all items added to the
+// ShardedBucket, rounded and saturated to an int64
+func (b *ShardedBucket) SumOfSquares() int64 {
+	stats := b.stats(false)
+	return saturate(stats.sumOfSquares)
+}
+
+// Mean returns the mean of all items added to the ShardedBucket, or 0
+// if there are none
+func (b *ShardedBucket) Mean() float64 {
+	stats := b.stats(false)
+	return stats.mean
+}
+
+// StdDev returns the population standard deviation of all items added
+// to the ShardedBucket, or 0 if there are none
+func (b *ShardedBucket) StdDev() float64 {
+	stats := b.stats(false)
+	return stats.stdDev()
+}
+
+// DataPoints returns the same DataPoint values as Bucket.DataPoints,
+// merged from every shard.  Note that this resets all values.
+func (b *ShardedBucket) DataPoints() []DataPoint {
+	b.config.lock()
+	stats := b.stats(true)
+	elapsed := b.config.elapsed(true)
+	b.config.unlock()
+
+	return b.config.dataPoints(stats, elapsed)
+}
+
+// snapshot returns the same DataPoints as DataPoints would, without
+// resetting any values.
+func (b *ShardedBucket) snapshot() []DataPoint {
+	b.config.lock()
+	stats := b.stats(false)
+	elapsed := b.config.elapsed(false)
+	b.config.unlock()
+
+	return b.config.dataPoints(stats, elapsed)
+}
+
+// metricOf returns which of the ShardedBucket's metrics dp is, if any
+func (b *ShardedBucket) metricOf(dp DataPoint) (int, bool) {
+	return b.config.metricOf(dp)
+}
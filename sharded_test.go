@@ -0,0 +1,219 @@
+package signalfx
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+// bucketValues maps the rollup of each of dps to its value
+func bucketValues(dps []DataPoint) map[string]int64 {
+	ret := make(map[string]int64, len(dps))
+	for _, dp := range dps {
+		ret[dp.Dimensions["rollup"]] = dp.Value
+	}
+	return ret
+}
+
+func TestShardedCounter(t *testing.T) {
+	Convey("Sharded counters should behave as specified", t, func() {
+		c := NewShardedCounter("counter", nil, 0)
+		So(len(c.shards), ShouldEqual, c.mask+1)
+		So(c.mask&(c.mask+1), ShouldEqual, 0)
+		So(c.DataPoint(), ShouldBeNil)
+
+		So(func() {
+			c.PostReportHook(-1)
+		}, ShouldPanic)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					c.Inc(1)
+				}
+			}()
+		}
+		wg.Wait()
+		So(c.Value(), ShouldEqual, 16000)
+
+		cdp := c.DataPoint()
+		So(cdp, ShouldNotBeNil)
+		So(cdp.Metric, ShouldEqual, "counter")
+		So(cdp.Type, ShouldEqual, CounterType)
+		So(cdp.Value, ShouldEqual, 16000)
+
+		c.Inc(5)
+		c.PostReportHook(cdp.Value)
+		So(c.Value(), ShouldEqual, 5)
+		So(c.DataPoint().Value, ShouldEqual, 5)
+
+		c = NewShardedCounter("counter", nil, 7)
+		So(c.DataPoint().Value, ShouldEqual, 7)
+	})
+
+	Convey("Sharded counters should report every increment exactly once", t, func() {
+		config := NewConfig()
+		config.RoundTripper = okRoundTripper(nil)
+		reporter := NewReporter(config, nil)
+		c := NewShardedCounter("counter", nil, 0)
+		reporter.Track(c)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10000; j++ {
+					c.Inc(1)
+				}
+			}()
+		}
+
+		var reported int64
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		for finished := false; !finished; {
+			select {
+			case <-done:
+				finished = true
+			default:
+			}
+			dps, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			for _, dp := range dps {
+				reported += dp.Value
+			}
+		}
+		So(reported, ShouldEqual, 80000)
+		So(c.Value(), ShouldEqual, 0)
+	})
+}
+
+func TestShardedBucket(t *testing.T) {
+	Convey("Sharded buckets should behave as specified", t, func() {
+		b := NewShardedBucket("bucket", map[string]string{"a": "1"})
+		So(b.Metric(), ShouldEqual, "bucket")
+		So(b.Dimensions(), ShouldResemble, map[string]string{"a": "1"})
+		So(b.Count(), ShouldEqual, 0)
+		So(bucketValues(b.DataPoints()), ShouldResemble,
+			map[string]int64{"count": 0, "sum": 0, "sumofsquares": 0})
+
+		plain := NewBucket("bucket", nil)
+		var (
+			wg sync.WaitGroup
+			mu sync.Mutex
+		)
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := int64(-50); j < 50; j++ {
+					b.Add(j * int64(i))
+					mu.Lock()
+					plain.Add(j * int64(i))
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		So(b.Count(), ShouldEqual, plain.Count())
+		So(b.Min(), ShouldEqual, plain.Min())
+		So(b.Max(), ShouldEqual, plain.Max())
+		So(b.Sum(), ShouldEqual, plain.Sum())
+		So(b.SumOfSquares(), ShouldEqual, plain.SumOfSquares())
+		So(b.Mean(), ShouldAlmostEqual, plain.Mean(), 1e-9)
+		So(b.StdDev(), ShouldAlmostEqual, plain.StdDev(), 1e-9)
+		So(b.Overflowed(), ShouldBeFalse)
+
+		b.Enable(BucketMetricMean, BucketMetricStdDev)
+		plain.Enable(BucketMetricMean, BucketMetricStdDev)
+
+		expected := bucketValues(plain.DataPoints())
+		So(bucketValues(b.snapshot()), ShouldResemble, expected)
+		So(b.Count(), ShouldEqual, 1600)
+		So(bucketValues(b.DataPoints()), ShouldResemble, expected)
+		So(b.Count(), ShouldEqual, 0)
+		So(bucketValues(b.DataPoints()), ShouldResemble, bucketValues(plain.DataPoints()))
+
+		b.Add(3)
+		b.Disable(BucketMetricSum, BucketMetricSumOfSquares, BucketMetricMean, BucketMetricStdDev)
+		b.SetMetric("other")
+		b.SetDimension("b", "2")
+		b.RemoveDimension("a")
+		dps := b.DataPoints()
+		So(bucketValues(dps), ShouldResemble, map[string]int64{"count": 1, "min": 3, "max": 3})
+		So(dps[0].Metric, ShouldEqual, "other")
+		So(dps[0].Dimensions["b"], ShouldEqual, "2")
+		So(dps[0].Dimensions["a"], ShouldEqual, "")
+	})
+
+	Convey("Reporters should track sharded buckets", t, func() {
+		config := NewConfig()
+		config.RoundTripper = okRoundTripper(nil)
+		reporter := NewReporter(config, nil)
+
+		b := reporter.NewShardedBucket("bucket", nil)
+		So(len(reporter.buckets), ShouldEqual, 1)
+		b.Add(2)
+		b.Add(4)
+
+		So(bucketValues(reporter.Snapshot(false))["sum"], ShouldEqual, 6)
+		dps, err := reporter.Report(context.Background())
+		So(err, ShouldBeNil)
+		So(bucketValues(dps), ShouldResemble,
+			map[string]int64{"count": 2, "min": 2, "max": 4, "sum": 6, "sumofsquares": 20})
+		So(b.Count(), ShouldEqual, 0)
+
+		reporter.RemoveShardedBucket(b)
+		So(len(reporter.buckets), ShouldEqual, 0)
+	})
+}
+
+func BenchmarkCounterParallel(b *testing.B) {
+	c := NewCounter("counter", nil, 0)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Inc(1)
+		}
+	})
+}
+
+func BenchmarkShardedCounterParallel(b *testing.B) {
+	c := NewShardedCounter("counter", nil, 0)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Inc(1)
+		}
+	})
+}
+
+func BenchmarkBucketParallel(b *testing.B) {
+	bucket := NewBucket("bucket", nil)
+	b.RunParallel(func(pb *testing.PB) {
+		var i int64
+		for pb.Next() {
+			bucket.Add(i)
+			i++
+		}
+	})
+}
+
+func BenchmarkShardedBucketParallel(b *testing.B) {
+	bucket := NewShardedBucket("bucket", nil)
+	b.RunParallel(func(pb *testing.PB) {
+		var i int64
+		for pb.Next() {
+			bucket.Add(i)
+			i++
+		}
+	})
+}
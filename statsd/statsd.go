@@ -0,0 +1,432 @@
+/*
+Package statsd receives metrics in the StatsD protocol and reports them to
+SignalFx through a signalfx.Reporter.
+
+A Server listens on a UDP or unix datagram socket:
+
+	srv := statsd.NewServer(reporter, map[string]string{"source": "statsd"})
+	go srv.ListenAndServe("udp", ":8125")
+	defer srv.Close()
+
+Each line of a packet is a single metric, such as:
+
+	requests:1|c
+	requests:1|c|@0.1
+	queue-depth:12|g
+	queue-depth:-2|g
+	latency:320|ms|#route:/api,method:get
+	users:alice|s
+
+Counters (c) are aggregated into a signalfx.Counter, scaled by their sample
+rate. Gauges (g) are recorded into a signalfx.Gauge, with a leading sign
+adjusting the previous value. Timers (ms), as well as DogStatsD histograms
+(h) and distributions (d), are added to a signalfx.Bucket, as many times as
+their sample rate implies; sample rates below MinSampleRate are invalid. Sets
+(s) are reported as a gauge of the number of unique values seen within each
+reporting interval.
+
+A Server tracks at most DefaultMaxSeries distinct series (metric name, type
+and tags), unless changed with SetMaxSeries; lines of further series are
+dropped, and counted.
+
+DogStatsD tags (#key:value,...) become dimensions; tags without a value are
+ignored. Values are rounded to integers.
+*/
+package statsd
+
+import (
+	"errors"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"zvelo.io/go-signalfx"
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+const (
+	// MaxPacketSize is the largest datagram read by a Server
+	MaxPacketSize = 65535
+
+	// MetricInvalidLines is the counter of lines which could not be
+	// parsed
+	MetricInvalidLines = "statsd-invalid-lines"
+
+	// MetricDroppedLines is the counter of lines dropped since their
+	// series would exceed the maximum number of series
+	MetricDroppedLines = "statsd-dropped-lines"
+
+	// MinSampleRate is the lowest sample rate accepted, so that a
+	// single line may not stand for more than 1/MinSampleRate values
+	MinSampleRate = 0.001
+
+	// DefaultMaxSeries is the default maximum number of series a Server
+	// tracks
+	DefaultMaxSeries = 10000
+)
+
+var (
+	// ErrInvalidLine is returned by HandleLine for a line which is not
+	// in the StatsD format
+	ErrInvalidLine = errors.New("invalid statsd line")
+
+	// ErrClosed is returned when serving a closed Server
+	ErrClosed = errors.New("statsd server closed")
+
+	// ErrTooManySeries is returned by HandleLine for a line of a new
+	// series when the Server already tracks its maximum number of series
+	ErrTooManySeries = errors.New("too many statsd series")
+)
+
+// A line is a single parsed StatsD metric
+type line struct {
+	name  string
+	value string
+	typ   string
+	rate  float64
+	tags  map[string]string
+}
+
+// parseLine parses name:value|type[|@rate][|#tags]
+func parseLine(s string) (line, error) {
+	ret := line{rate: 1}
+
+	i := strings.LastIndex(strings.SplitN(s, "|", 2)[0], ":")
+	if i <= 0 {
+		return ret, ErrInvalidLine
+	}
+	ret.name = s[:i]
+
+	parts := strings.Split(s[i+1:], "|")
+	if len(parts) < 2 || parts[0] == "" {
+		return ret, ErrInvalidLine
+	}
+	ret.value, ret.typ = parts[0], parts[1]
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate < MinSampleRate || rate > 1 {
+				return ret, ErrInvalidLine
+			}
+			ret.rate = rate
+		case strings.HasPrefix(part, "#"):
+			ret.tags = map[string]string{}
+			for _, tag := range strings.Split(part[1:], ",") {
+				if j := strings.Index(tag, ":"); j > 0 && j < len(tag)-1 {
+					ret.tags[tag[:j]] = tag[j+1:]
+				}
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// key returns the key identifying the series of l
+func (l line) key() string {
+	pairs := make([]string, 0, len(l.tags))
+	for k, v := range l.tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return l.typ + "|" + l.name + "|" + strings.Join(pairs, ",")
+}
+
+// number parses the value of l as a float which may be rounded to an
+// int64
+func (l line) number() (float64, error) {
+	v, err := strconv.ParseFloat(l.value, 64)
+	if err != nil || math.IsNaN(v) || v >= math.MaxInt64 || v <= math.MinInt64 {
+		return 0, ErrInvalidLine
+	}
+	return v, nil
+}
+
+// A set is the unique values of a StatsD set seen within an interval
+type set struct {
+	gauge  *signalfx.Gauge
+	values map[string]struct{}
+}
+
+// A Server aggregates StatsD metrics into metrics tracked by a Reporter.
+// All operations on a Server are goroutine safe.
+type Server struct {
+	reporter   *signalfx.Reporter
+	dimensions map[string]string
+	invalid    *signalfx.Counter
+	dropped    *signalfx.Counter
+	counters   map[string]*signalfx.Counter
+	gauges     map[string]*signalfx.Gauge
+	buckets    map[string]*signalfx.Bucket
+	sets       map[string]*set
+	series     int
+	maxSeries  int
+	conns      []net.PacketConn
+	closed     bool
+	mu         sync.Mutex
+
+	// setsMu guards the sets and their values, which preReport resets.
+	// Since preReport is called by the Reporter with its own lock held,
+	// setsMu must never be held while calling the Reporter, unlike mu.
+	setsMu sync.Mutex
+}
+
+// NewServer returns a new Server which reports to reporter. dims are
+// added to every metric, and are copied.
+func NewServer(reporter *signalfx.Reporter, dims map[string]string) *Server {
+	ret := &Server{
+		reporter:   reporter,
+		dimensions: sfxproto.Dimensions(dims).Clone(),
+		counters:   map[string]*signalfx.Counter{},
+		gauges:     map[string]*signalfx.Gauge{},
+		buckets:    map[string]*signalfx.Bucket{},
+		sets:       map[string]*set{},
+		maxSeries:  DefaultMaxSeries,
+	}
+	ret.invalid = signalfx.NewCounter(MetricInvalidLines, ret.dimensions, 0)
+	ret.dropped = signalfx.NewCounter(MetricDroppedLines, ret.dimensions, 0)
+	reporter.Track(ret.invalid, ret.dropped)
+	reporter.AddPreReportCallback(ret.preReport)
+	return ret
+}
+
+// preReport records the size of each set, and starts a new interval
+func (s *Server) preReport() {
+	s.setsMu.Lock()
+	defer s.setsMu.Unlock()
+
+	for _, st := range s.sets {
+		st.gauge.Record(int64(len(st.values)))
+		st.values = map[string]struct{}{}
+	}
+}
+
+// HandleLine aggregates a single StatsD line
+func (s *Server) HandleLine(text string) error {
+	l, err := parseLine(text)
+	if err == nil {
+		err = s.handle(l)
+	}
+	switch err {
+	case ErrInvalidLine:
+		s.invalid.Inc(1)
+	case ErrTooManySeries:
+		s.dropped.Inc(1)
+	}
+	return err
+}
+
+// SetMaxSeries sets the maximum number of series the Server tracks.
+// Series already tracked are kept.
+func (s *Server) SetMaxSeries(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxSeries = n
+}
+
+// newSeries counts a new series, returning ErrTooManySeries if there
+// are already too many
+func (s *Server) newSeries() error {
+	if s.series >= s.maxSeries {
+		return ErrTooManySeries
+	}
+	s.series++
+	return nil
+}
+
+// HandlePacket aggregates each newline-separated line of a packet,
+// returning the first error encountered
+func (s *Server) HandlePacket(packet []byte) error {
+	var ret error
+	for _, text := range strings.Split(string(packet), "\n") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if err := s.HandleLine(text); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+func (s *Server) handle(l line) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	key := l.key()
+	dims := sfxproto.Dimensions(s.dimensions).Append(l.tags)
+
+	switch l.typ {
+	case "c":
+		v, err := l.number()
+		if err != nil || v < 0 {
+			return ErrInvalidLine
+		}
+		c, ok := s.counters[key]
+		if !ok {
+			if err = s.newSeries(); err != nil {
+				return err
+			}
+			c = signalfx.NewCounter(l.name, dims, 0)
+			s.counters[key] = c
+			s.reporter.Track(c)
+		}
+		c.Inc(uint64(math.Round(v / l.rate)))
+
+	case "g":
+		v, err := l.number()
+		if err != nil {
+			return err
+		}
+		g, ok := s.gauges[key]
+		if !ok {
+			if err = s.newSeries(); err != nil {
+				return err
+			}
+			g = signalfx.NewGauge(l.name, dims, 0)
+			s.gauges[key] = g
+			s.reporter.Track(g)
+		}
+		if l.value[0] == '+' || l.value[0] == '-' {
+			v += float64(g.DataPoint().Value)
+		}
+		g.Record(int64(math.Round(v)))
+
+	case "ms", "h", "d":
+		v, err := l.number()
+		if err != nil {
+			return err
+		}
+		b, ok := s.buckets[key]
+		if !ok {
+			if err = s.newSeries(); err != nil {
+				return err
+			}
+			b = s.reporter.NewBucket(l.name, dims)
+			s.buckets[key] = b
+		}
+		for n := int(math.Round(1 / l.rate)); n > 0; n-- {
+			b.Add(int64(math.Round(v)))
+		}
+
+	case "s":
+		s.setsMu.Lock()
+		st, ok := s.sets[key]
+		if !ok {
+			if err := s.newSeries(); err != nil {
+				s.setsMu.Unlock()
+				return err
+			}
+			st = &set{
+				gauge:  signalfx.NewGauge(l.name, dims, 0),
+				values: map[string]struct{}{},
+			}
+			s.sets[key] = st
+		}
+		st.values[l.value] = struct{}{}
+		s.setsMu.Unlock()
+		if !ok {
+			s.reporter.Track(st.gauge)
+		}
+
+	default:
+		return ErrInvalidLine
+	}
+
+	return nil
+}
+
+// Serve reads packets from conn until it is closed. Serve closes conn
+// when the Server is closed.
+func (s *Server) Serve(conn net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = conn.Close()
+		return ErrClosed
+	}
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+
+	buf := make([]byte, MaxPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if n > 0 {
+			_ = s.HandlePacket(buf[:n])
+		}
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+	}
+}
+
+// ListenAndServe listens on network ("udp", "udp4", "udp6" or
+// "unixgram") at addr, and then calls Serve. A unix socket is removed
+// once it is no longer served.
+func (s *Server) ListenAndServe(network, addr string) error {
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return err
+	}
+	if network == "unixgram" {
+		defer func() { _ = os.Remove(addr) }()
+	}
+	return s.Serve(conn)
+}
+
+// Close stops the Server, closing the connections it serves and
+// removing its metrics from the Reporter. Implements the io.Closer
+// interface.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	var ret error
+	for _, conn := range s.conns {
+		if err := conn.Close(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+
+	s.reporter.Untrack(s.invalid, s.dropped)
+	for _, c := range s.counters {
+		s.reporter.Untrack(c)
+	}
+	for _, g := range s.gauges {
+		s.reporter.Untrack(g)
+	}
+	for _, b := range s.buckets {
+		s.reporter.RemoveBucket(b)
+	}
+	for _, st := range s.sets {
+		s.reporter.Untrack(st.gauge)
+	}
+
+	return ret
+}
@@ -0,0 +1,190 @@
+package statsd
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+	"zvelo.io/go-signalfx"
+)
+
+func TestStatsd(t *testing.T) {
+	Convey("Testing statsd", t, func() {
+		Convey("lines should be parsed", func() {
+			l, err := parseLine("a.b:1.5|ms|@0.5|#route:/api,method:get,bare")
+			So(err, ShouldBeNil)
+			So(l.name, ShouldEqual, "a.b")
+			So(l.value, ShouldEqual, "1.5")
+			So(l.typ, ShouldEqual, "ms")
+			So(l.rate, ShouldEqual, 0.5)
+			So(l.tags, ShouldResemble, map[string]string{"route": "/api", "method": "get"})
+			So(l.key(), ShouldEqual, "ms|a.b|method=get,route=/api")
+
+			l, err = parseLine("users:alice|s")
+			So(err, ShouldBeNil)
+			So(l.name, ShouldEqual, "users")
+			So(l.rate, ShouldEqual, 1)
+
+			for _, s := range []string{"", "a", ":1|c", "a:|c", "a:1", "a:1|c|@2", "a:1|c|@x", "a:1|ms|@0.000000001"} {
+				_, err = parseLine(s)
+				So(err, ShouldEqual, ErrInvalidLine)
+			}
+		})
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`"OK"`))
+		}))
+		defer ts.Close()
+
+		config := signalfx.NewConfig()
+		config.URL = ts.URL
+		reporter := signalfx.NewReporter(config, nil)
+		srv := NewServer(reporter, map[string]string{"source": "statsd"})
+		defer srv.Close()
+
+		report := func() map[string]int64 {
+			dps, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			ret := map[string]int64{}
+			for _, dp := range dps {
+				So(dp.Dimensions["source"], ShouldEqual, "statsd")
+				key := dp.Metric
+				if rollup, ok := dp.Dimensions["rollup"]; ok {
+					key += "." + rollup
+				}
+				if route, ok := dp.Dimensions["route"]; ok {
+					key += "." + route
+				}
+				ret[key] = dp.Value
+			}
+			return ret
+		}
+
+		Convey("metrics should be aggregated", func() {
+			So(srv.HandlePacket([]byte(`requests:1|c
+requests:2|c|@0.5
+requests:1|c|#route:a
+depth:10|g
+depth:-3|g
+depth:+1|g
+latency:10|ms
+latency:20.4|ms|@0.5
+users:alice|s
+users:bob|s
+users:alice|s
+`)), ShouldBeNil)
+			So(srv.HandleLine("bad"), ShouldEqual, ErrInvalidLine)
+			So(srv.HandleLine("a:1|x"), ShouldEqual, ErrInvalidLine)
+			So(srv.HandleLine("a:-1|c"), ShouldEqual, ErrInvalidLine)
+
+			values := report()
+			So(values["requests"], ShouldEqual, 5)
+			So(values["requests.a"], ShouldEqual, 1)
+			So(values["depth"], ShouldEqual, 8)
+			So(values["latency.count"], ShouldEqual, 3)
+			So(values["latency.sum"], ShouldEqual, 50)
+			So(values["latency.max"], ShouldEqual, 20)
+			So(values["users"], ShouldEqual, 2)
+			So(values[MetricInvalidLines], ShouldEqual, 3)
+
+			Convey("and reset with each interval", func() {
+				So(srv.HandleLine("users:carol|s"), ShouldBeNil)
+
+				values := report()
+				_, ok := values["requests"]
+				So(ok, ShouldBeFalse)
+				So(values["depth"], ShouldEqual, 8)
+				So(values["latency.count"], ShouldEqual, 0)
+				So(values["users"], ShouldEqual, 1)
+			})
+		})
+
+		Convey("the number of series should be bounded", func() {
+			srv.SetMaxSeries(2)
+			So(srv.HandleLine("a:1|c"), ShouldBeNil)
+			So(srv.HandleLine("b:1|g"), ShouldBeNil)
+			So(srv.HandleLine("c:1|ms"), ShouldEqual, ErrTooManySeries)
+			So(srv.HandleLine("d:x|s"), ShouldEqual, ErrTooManySeries)
+			So(srv.HandleLine("a:1|c|#t:1"), ShouldEqual, ErrTooManySeries)
+
+			// known series are still aggregated
+			So(srv.HandleLine("a:2|c"), ShouldBeNil)
+			values := report()
+			So(values["a"], ShouldEqual, 3)
+			So(values[MetricDroppedLines], ShouldEqual, 3)
+			_, ok := values["c.count"]
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("lines should be handled while reporting", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 2000; i++ {
+					for _, text := range []string{
+						"users:" + strconv.Itoa(i) + "|s",
+						"requests:1|c|#i:" + strconv.Itoa(i),
+						"latency:" + strconv.Itoa(i) + "|ms|#i:" + strconv.Itoa(i),
+					} {
+						_ = srv.HandleLine(text)
+					}
+				}
+			}()
+
+			reported := make(chan struct{})
+			go func() {
+				defer close(reported)
+				for {
+					select {
+					case <-done:
+						return
+					default:
+						_, _ = reporter.Report(context.Background())
+					}
+				}
+			}()
+
+			deadlocked := false
+			for _, c := range []chan struct{}{done, reported} {
+				select {
+				case <-c:
+				case <-time.After(10 * time.Second):
+					deadlocked = true
+				}
+			}
+			So(deadlocked, ShouldBeFalse)
+			So(report(), ShouldContainKey, "users")
+		})
+
+		Convey("packets should be served over UDP", func() {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			errc := make(chan error, 1)
+			go func() { errc <- srv.Serve(conn) }()
+
+			client, err := net.Dial("udp", conn.LocalAddr().String())
+			So(err, ShouldBeNil)
+			defer client.Close()
+			_, err = client.Write([]byte("requests:4|c\nrequests:1|c"))
+			So(err, ShouldBeNil)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				if dps := reporter.Snapshot(false); len(dps) > 0 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(report()["requests"], ShouldEqual, 5)
+
+			So(srv.Close(), ShouldBeNil)
+			So(<-errc, ShouldEqual, ErrClosed)
+			So(srv.HandleLine("requests:1|c"), ShouldEqual, ErrClosed)
+		})
+	})
+}
@@ -0,0 +1,208 @@
+package signalfx
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// Precisions of a UniqueCounter
+const (
+	MinUniqueCounterPrecision     = 4
+	MaxUniqueCounterPrecision     = 18
+	DefaultUniqueCounterPrecision = 14
+)
+
+// A UniqueCounter is a Metric reporting, as a gauge, the estimated
+// number of distinct values observed since it was last reported.  It
+// uses a HyperLogLog sketch of 2^precision registers, one byte each, so
+// that its memory is bounded however many values are observed; its
+// standard error is about 1.04/sqrt(2^precision), e.g. 0.8% with the
+// default precision of 14.  All operations on UniqueCounters are
+// goroutine safe.
+//
+// DataPoint sets the observed values aside, observing new ones in a
+// fresh sketch, and PostReportHook discards them.  If a report fails,
+// or if DataPoint is called without reporting, the values set aside are
+// merged back into the next DataPoint, so none of them is lost.
+type UniqueCounter struct {
+	metric     string
+	dimensions map[string]string
+	precision  uint8
+	seed       maphash.Seed
+	mu         sync.Mutex
+
+	// registers are those of the values observed since the last
+	// DataPoint, and pending those of the values it set aside; spare
+	// is a zeroed sketch with which to replace registers
+	registers []uint8
+	pending   []uint8
+	spare     []uint8
+}
+
+// NewUniqueCounter returns a new UniqueCounter whose sketch has
+// 2^precision registers; precision is clamped between
+// MinUniqueCounterPrecision and MaxUniqueCounterPrecision.  It does not
+// copy the dimensions; client code should take care not to modify them
+// in a goroutine-unsafe manner.
+func NewUniqueCounter(metric string, dimensions map[string]string, precision uint8) *UniqueCounter {
+	if precision < MinUniqueCounterPrecision {
+		precision = MinUniqueCounterPrecision
+	}
+	if precision > MaxUniqueCounterPrecision {
+		precision = MaxUniqueCounterPrecision
+	}
+	return &UniqueCounter{
+		metric:     metric,
+		dimensions: dimensions,
+		precision:  precision,
+		seed:       maphash.MakeSeed(),
+		registers:  make([]uint8, 1<<precision),
+	}
+}
+
+// observe records the hash of a value
+func (u *UniqueCounter) observe(hash uint64) {
+	index := hash >> (64 - u.precision)
+	rho := uint8(bits.LeadingZeros64(hash<<u.precision|1<<(u.precision-1)) + 1)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if rho > u.registers[index] {
+		u.registers[index] = rho
+	}
+}
+
+// Observe records a value
+func (u *UniqueCounter) Observe(value string) {
+	u.observe(maphash.String(u.seed, value))
+}
+
+// ObserveBytes records a value
+func (u *UniqueCounter) ObserveBytes(value []byte) {
+	u.observe(maphash.Bytes(u.seed, value))
+}
+
+// mergeRegisters merges the registers of src into dst
+func mergeRegisters(dst, src []uint8) {
+	for i, r := range src {
+		if r > dst[i] {
+			dst[i] = r
+		}
+	}
+}
+
+// estimate returns the estimated number of distinct values whose
+// registers are given
+func estimate(registers []uint8) uint64 {
+	m := float64(len(registers))
+
+	var alpha float64
+	switch len(registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+
+	var (
+		sum   float64
+		zeros int
+	)
+	for _, r := range registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small cardinalities
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+// Estimate returns the estimated number of distinct values observed
+// since the UniqueCounter was last reported.  It does not reset it.
+func (u *UniqueCounter) Estimate() uint64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.pending == nil {
+		return estimate(u.registers)
+	}
+	merged := make([]uint8, len(u.registers))
+	copy(merged, u.pending)
+	mergeRegisters(merged, u.registers)
+	return estimate(merged)
+}
+
+// DataPoint returns a gauge of the estimated number of distinct values
+// observed since the UniqueCounter was last reported, setting them
+// aside until PostReportHook is called.
+func (u *UniqueCounter) DataPoint() *DataPoint {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.pending == nil {
+		u.pending = u.registers
+		if u.spare != nil {
+			u.registers, u.spare = u.spare, nil
+		} else {
+			u.registers = make([]uint8, len(u.pending))
+		}
+	} else {
+		mergeRegisters(u.pending, u.registers)
+		for i := range u.registers {
+			u.registers[i] = 0
+		}
+	}
+
+	return u.dataPoint(estimate(u.pending))
+}
+
+// snapshot returns the DataPoint which DataPoint would, without setting
+// the values aside.
+func (u *UniqueCounter) snapshot() *DataPoint {
+	return u.dataPoint(u.Estimate())
+}
+
+func (u *UniqueCounter) dataPoint(value uint64) *DataPoint {
+	if value > math.MaxInt64 {
+		value = math.MaxInt64
+	}
+	return &DataPoint{
+		Metric:     u.metric,
+		Timestamp:  time.Now(),
+		Type:       GaugeType,
+		Dimensions: u.dimensions,
+		Value:      int64(value),
+	}
+}
+
+// PostReportHook discards the values set aside by DataPoint, since they
+// have been reported.  Its argument is ignored.
+//
+// In the normal case, PostReportHook should only be called by
+// Reporter.Report.
+func (u *UniqueCounter) PostReportHook(int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.pending == nil {
+		return
+	}
+	for i := range u.pending {
+		u.pending[i] = 0
+	}
+	u.pending, u.spare = nil, u.pending
+}
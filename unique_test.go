@@ -0,0 +1,125 @@
+package signalfx
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+// withinError returns whether estimate is within err (relative) of
+// actual
+func withinError(estimate, actual uint64, err float64) bool {
+	return math.Abs(float64(estimate)-float64(actual)) <= err*float64(actual)
+}
+
+func TestUniqueCounter(t *testing.T) {
+	Convey("Testing UniqueCounter", t, func() {
+		u := NewUniqueCounter("users", map[string]string{"c": "3"}, DefaultUniqueCounterPrecision)
+		So(len(u.registers), ShouldEqual, 1<<14)
+		So(u.Estimate(), ShouldEqual, 0)
+
+		So(len(NewUniqueCounter("x", nil, 0).registers), ShouldEqual, 1<<MinUniqueCounterPrecision)
+		So(len(NewUniqueCounter("x", nil, 30).registers), ShouldEqual, 1<<MaxUniqueCounterPrecision)
+
+		Convey("small and large cardinalities should be estimated", func() {
+			for i := 0; i < 3; i++ {
+				u.Observe("a")
+				u.ObserveBytes([]byte("b"))
+				u.Observe("c")
+			}
+			So(u.Estimate(), ShouldEqual, 3)
+
+			for i := 0; i < 100000; i++ {
+				u.Observe(fmt.Sprint("user-", i))
+			}
+			So(withinError(u.Estimate(), 100003, 0.03), ShouldBeTrue)
+
+			dp := u.DataPoint()
+			So(dp.Metric, ShouldEqual, "users")
+			So(dp.Type, ShouldEqual, GaugeType)
+			So(dp.Dimensions, ShouldResemble, map[string]string{"c": "3"})
+			So(withinError(uint64(dp.Value), 100003, 0.03), ShouldBeTrue)
+		})
+
+		Convey("it should be safe for concurrent use", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 1000; j++ {
+						u.Observe(fmt.Sprint(i, "-", j))
+						if j%100 == 0 {
+							u.Estimate()
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+			So(withinError(u.Estimate(), 8000, 0.03), ShouldBeTrue)
+		})
+
+		Convey("it should reset after PostReportHook", func() {
+			u.Observe("a")
+			u.Observe("b")
+			dp := u.DataPoint()
+			So(dp.Value, ShouldEqual, 2)
+
+			// values observed between DataPoint and PostReportHook are
+			// reported next time
+			u.Observe("c")
+			u.PostReportHook(dp.Value)
+			So(u.Estimate(), ShouldEqual, 1)
+			So(u.DataPoint().Value, ShouldEqual, 1)
+			u.PostReportHook(1)
+			So(u.Estimate(), ShouldEqual, 0)
+			So(u.DataPoint().Value, ShouldEqual, 0)
+		})
+
+		Convey("values should not be lost without PostReportHook", func() {
+			u.Observe("a")
+			So(u.DataPoint().Value, ShouldEqual, 1)
+			u.Observe("b")
+			So(u.Estimate(), ShouldEqual, 2)
+			So(u.DataPoint().Value, ShouldEqual, 2)
+		})
+
+		Convey("reporters should report and reset it", func() {
+			config := NewConfig()
+			config.RoundTripper = okRoundTripper(nil)
+			reporter := NewReporter(config, nil)
+			reporter.Track(u)
+
+			u.Observe("a")
+			u.Observe("b")
+			So(reporter.Snapshot(false)[0].Value, ShouldEqual, 2)
+			So(u.pending, ShouldBeNil)
+
+			dps, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(dps[0].Value, ShouldEqual, 2)
+
+			dps, err = reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(dps[0].Value, ShouldEqual, 0)
+		})
+	})
+}
+
+func BenchmarkUniqueCounterObserve(b *testing.B) {
+	u := NewUniqueCounter("users", nil, DefaultUniqueCounterPrecision)
+	values := make([]string, 1024)
+	for i := range values {
+		values[i] = fmt.Sprint("user-", i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		u.Observe(values[i%len(values)])
+	}
+}
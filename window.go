@@ -0,0 +1,395 @@
+package signalfx
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"zvelo.io/go-signalfx/sfxproto"
+)
+
+// Defaults of WindowOptions
+const (
+	DefaultWindow           = time.Minute
+	DefaultWindowSubBuckets = 6
+)
+
+// DefaultWindowQuantiles are the quantiles reported by a Window unless
+// WindowOptions.Quantiles is set
+var DefaultWindowQuantiles = []float64{0.5, 0.99}
+
+// windowAccuracy is the relative accuracy of the quantiles of a Window
+const windowAccuracy = 0.01
+
+var (
+	windowGamma    = (1 + windowAccuracy) / (1 - windowAccuracy)
+	windowLogGamma = math.Log(windowGamma)
+)
+
+// WindowOptions configure a Window.
+type WindowOptions struct {
+	// Window is the duration over which values are aggregated.  If
+	// zero, DefaultWindow is used.
+	Window time.Duration
+
+	// SubBuckets is the number of sub-buckets Window is divided into,
+	// each of which expires at once.  If zero, DefaultWindowSubBuckets
+	// is used.
+	SubBuckets int
+
+	// Quantiles are the quantiles reported, between 0 and 1.  If nil,
+	// DefaultWindowQuantiles are used.
+	Quantiles []float64
+
+	// Naming and RollupKey name the DataPoints of each statistic, as
+	// those of BucketOptions do.
+	Naming    BucketNaming
+	RollupKey string
+}
+
+// windowSketch counts values in buckets whose bounds grow
+// exponentially, so that any quantile may be estimated to within
+// windowAccuracy of its actual value
+type windowSketch struct {
+	positive map[int]uint64
+	negative map[int]uint64
+	zero     uint64
+}
+
+// windowKey returns the key of the bucket of v, which must be positive
+func windowKey(v float64) int {
+	return int(math.Ceil(math.Log(v) / windowLogGamma))
+}
+
+// windowValue returns the estimated value of the bucket of key
+func windowValue(key int) float64 {
+	return 2 * math.Pow(windowGamma, float64(key)) / (windowGamma + 1)
+}
+
+func (s *windowSketch) add(val int64) {
+	switch {
+	case val > 0:
+		if s.positive == nil {
+			s.positive = map[int]uint64{}
+		}
+		s.positive[windowKey(float64(val))]++
+	case val < 0:
+		if s.negative == nil {
+			s.negative = map[int]uint64{}
+		}
+		s.negative[windowKey(-float64(val))]++
+	default:
+		s.zero++
+	}
+}
+
+func (s *windowSketch) merge(o windowSketch) {
+	for k, n := range o.positive {
+		if s.positive == nil {
+			s.positive = map[int]uint64{}
+		}
+		s.positive[k] += n
+	}
+	for k, n := range o.negative {
+		if s.negative == nil {
+			s.negative = map[int]uint64{}
+		}
+		s.negative[k] += n
+	}
+	s.zero += o.zero
+}
+
+// quantile returns the estimated q-quantile, by nearest rank, of the
+// count values in the sketch
+func (s *windowSketch) quantile(q float64, count uint64) float64 {
+	rank := uint64(math.Ceil(q * float64(count)))
+	if rank > 0 {
+		rank--
+	}
+
+	keys := make([]int, 0, len(s.negative))
+	for k := range s.negative {
+		keys = append(keys, k)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	var seen uint64
+	for _, k := range keys {
+		if seen += s.negative[k]; seen > rank {
+			return -windowValue(k)
+		}
+	}
+
+	if seen += s.zero; seen > rank {
+		return 0
+	}
+
+	keys = keys[:0]
+	for k := range s.positive {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	for _, k := range keys {
+		if seen += s.positive[k]; seen > rank {
+			return windowValue(k)
+		}
+	}
+	return 0
+}
+
+// windowSlot holds the values of a single sub-bucket
+type windowSlot struct {
+	epoch  int64
+	stats  bucketStats
+	sketch windowSketch
+}
+
+// A Window aggregates values over a sliding window, reporting their
+// count, min, max, mean and quantiles over its duration as gauges
+// named as those of a Bucket are, by default with a rollup dimension
+// such as rollup=p99.  Unlike a Bucket,
+// reading a Window does not reset it: values expire a sub-bucket at a
+// time as the window slides, so what it reports does not depend on how
+// often it is reported.  Since the current sub-bucket has only partly
+// elapsed, what is reported covers between (N-1)/N and all of the
+// window, for N sub-buckets.  Quantiles are estimated to within 1% of
+// their actual value.  All operations on Windows are goroutine safe.
+type Window struct {
+	metric     string
+	dimensions map[string]string
+	width      time.Duration
+	quantiles  []float64
+	naming     BucketNaming
+	rollupKey  string
+	slots      []windowSlot
+	closed     bool
+	mu         sync.Mutex
+
+	// now returns the current time, and may be replaced by tests
+	now func() time.Time
+}
+
+// NewWindow creates a new Window, configured by the first of options, if
+// any.  Its dimensions are copied.
+func NewWindow(metric string, dimensions map[string]string, options ...WindowOptions) *Window {
+	var opts WindowOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+	if opts.SubBuckets <= 0 {
+		opts.SubBuckets = DefaultWindowSubBuckets
+	}
+	if opts.Quantiles == nil {
+		opts.Quantiles = DefaultWindowQuantiles
+	}
+	if opts.RollupKey == "" {
+		opts.RollupKey = DefaultBucketRollupKey
+	}
+
+	width := opts.Window / time.Duration(opts.SubBuckets)
+	if width <= 0 {
+		width = 1
+	}
+
+	ret := &Window{
+		metric:     metric,
+		dimensions: sfxproto.Dimensions(dimensions).Clone(),
+		width:      width,
+		quantiles:  append([]float64(nil), opts.Quantiles...),
+		naming:     opts.Naming,
+		rollupKey:  opts.RollupKey,
+		slots:      make([]windowSlot, opts.SubBuckets),
+		now:        time.Now,
+	}
+	for i := range ret.slots {
+		ret.slots[i] = windowSlot{epoch: math.MinInt64, stats: newBucketStats()}
+	}
+	return ret
+}
+
+// NewWindow creates a new Window, as NewWindow does, that is reported
+// by the Reporter until it is closed or removed.
+func (r *Reporter) NewWindow(metric string, dimensions map[string]string, options ...WindowOptions) *Window {
+	ret := NewWindow(metric, dimensions, options...)
+
+	r.lock()
+	defer r.unlock()
+
+	r.windows[ret] = struct{}{}
+	return ret
+}
+
+// RemoveWindow stops reporting Windows created by NewWindow.  Like the
+// Reporter's other methods, it must not be called from a
+// DataPointCallback or PreReportCallback; Window.Close may be.
+func (r *Reporter) RemoveWindow(ws ...*Window) {
+	r.lock()
+	defer r.unlock()
+
+	for _, w := range ws {
+		delete(r.windows, w)
+	}
+}
+
+// epoch returns the index of the sub-bucket in which values added now
+// fall
+func (w *Window) epoch() int64 {
+	return w.now().UnixNano() / int64(w.width)
+}
+
+// Add an item to the Window
+func (w *Window) Add(val int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	epoch := w.epoch()
+	slot := &w.slots[int(uint64(epoch)%uint64(len(w.slots)))]
+	if slot.epoch != epoch {
+		*slot = windowSlot{epoch: epoch, stats: newBucketStats()}
+	}
+	slot.stats.add(val)
+	slot.sketch.add(val)
+}
+
+// merged returns the statistics of the values within the window
+func (w *Window) merged() (bucketStats, windowSketch) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	epoch := w.epoch()
+	stats := newBucketStats()
+	var sketch windowSketch
+	for i := range w.slots {
+		slot := &w.slots[i]
+		if slot.epoch > epoch-int64(len(w.slots)) && slot.epoch <= epoch {
+			stats.merge(slot.stats)
+			sketch.merge(slot.sketch)
+		}
+	}
+	return stats, sketch
+}
+
+// Count returns the number of items within the window
+func (w *Window) Count() uint64 {
+	stats, _ := w.merged()
+	return stats.count
+}
+
+// Min returns the lowest item within the window, or math.MaxInt64 if
+// there are none
+func (w *Window) Min() int64 {
+	stats, _ := w.merged()
+	return stats.min
+}
+
+// Max returns the highest item within the window, or math.MinInt64 if
+// there are none
+func (w *Window) Max() int64 {
+	stats, _ := w.merged()
+	return stats.max
+}
+
+// Mean returns the mean of the items within the window, or 0 if there
+// are none
+func (w *Window) Mean() float64 {
+	stats, _ := w.merged()
+	return stats.mean
+}
+
+// Quantile returns the estimated q-quantile of the items within the
+// window, or 0 if there are none
+func (w *Window) Quantile(q float64) int64 {
+	stats, sketch := w.merged()
+	return windowQuantile(stats, sketch, q)
+}
+
+// windowQuantile returns the estimated q-quantile of the values of
+// stats and sketch, within their min and max
+func windowQuantile(stats bucketStats, sketch windowSketch, q float64) int64 {
+	if stats.count == 0 {
+		return 0
+	}
+	switch {
+	case q <= 0:
+		return stats.min
+	case q >= 1:
+		return stats.max
+	}
+
+	v := saturate(sketch.quantile(q, stats.count))
+	if v < stats.min {
+		return stats.min
+	}
+	if v > stats.max {
+		return stats.max
+	}
+	return v
+}
+
+// quantileRollup returns the rollup of the q-quantile, e.g. p99 or p99.9
+func quantileRollup(q float64) string {
+	return "p" + strconv.FormatFloat(q*100, 'f', -1, 64)
+}
+
+// Close stops the Window from being reported.  The Reporter which
+// created it, if any, removes it when it next reports, so that Close
+// does not lock the Reporter, and may be called from a
+// DataPointCallback or PreReportCallback.
+func (w *Window) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	return nil
+}
+
+// isClosed returns whether the Window has been closed
+func (w *Window) isClosed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.closed
+}
+
+// DataPoints returns gauges of the count, min, max, mean and quantiles
+// of the items within the window, omitting all but the count if there
+// are none.  It does not reset the Window, and returns nothing once it
+// is closed.
+func (w *Window) DataPoints() []DataPoint {
+	if w.isClosed() {
+		return nil
+	}
+
+	stats, sketch := w.merged()
+	timestamp := time.Now()
+	dps := make([]DataPoint, 0, 4+len(w.quantiles))
+	add := func(rollup string, value int64) {
+		dp := DataPoint{
+			Metric:     w.metric,
+			Dimensions: w.dimensions,
+			Type:       GaugeType,
+			Value:      value,
+			Timestamp:  timestamp,
+		}
+		w.naming.apply(&dp, w.rollupKey, rollup)
+		dps = append(dps, dp)
+	}
+
+	if stats.count <= math.MaxInt64 {
+		add("count", int64(stats.count))
+	}
+	if stats.count == 0 {
+		return dps
+	}
+	add("min", stats.min)
+	add("max", stats.max)
+	add("mean", saturate(stats.mean))
+	for _, q := range w.quantiles {
+		add(quantileRollup(q), windowQuantile(stats, sketch, q))
+	}
+	return dps
+}
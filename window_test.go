@@ -0,0 +1,137 @@
+package signalfx
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestWindow(t *testing.T) {
+	Convey("Testing Window", t, func() {
+		now := time.Unix(1000, 0)
+		w := NewWindow("latency", map[string]string{"c": "3"})
+		w.now = func() time.Time { return now }
+
+		So(w.width, ShouldEqual, 10*time.Second)
+		So(len(w.slots), ShouldEqual, DefaultWindowSubBuckets)
+		So(w.Count(), ShouldEqual, 0)
+		So(w.Quantile(0.99), ShouldEqual, 0)
+		So(bucketValues(w.DataPoints()), ShouldResemble, map[string]int64{"count": 0})
+
+		Convey("values should be aggregated over the window", func() {
+			for i := int64(1); i <= 1000; i++ {
+				w.Add(i)
+			}
+			So(w.Count(), ShouldEqual, 1000)
+			So(w.Min(), ShouldEqual, 1)
+			So(w.Max(), ShouldEqual, 1000)
+			So(w.Mean(), ShouldEqual, 500.5)
+			So(w.Quantile(0), ShouldEqual, 1)
+			So(w.Quantile(1), ShouldEqual, 1000)
+			So(math.Abs(float64(w.Quantile(0.5)-500)), ShouldBeLessThanOrEqualTo, 5)
+			So(math.Abs(float64(w.Quantile(0.99)-990)), ShouldBeLessThanOrEqualTo, 10)
+
+			dps := w.DataPoints()
+			values := bucketValues(dps)
+			So(len(values), ShouldEqual, 6)
+			So(values["count"], ShouldEqual, 1000)
+			So(values["max"], ShouldEqual, 1000)
+			So(values["mean"], ShouldEqual, 501)
+			So(values["p99"], ShouldEqual, w.Quantile(0.99))
+			for _, dp := range dps {
+				So(dp.Type, ShouldEqual, GaugeType)
+				So(dp.Dimensions["c"], ShouldEqual, "3")
+			}
+
+			// reading is not destructive
+			So(bucketValues(w.DataPoints()), ShouldResemble, values)
+		})
+
+		Convey("values should expire as the window slides", func() {
+			w.Add(100)
+			now = now.Add(30 * time.Second)
+			w.Add(5)
+			So(w.Count(), ShouldEqual, 2)
+			So(w.Max(), ShouldEqual, 100)
+
+			now = now.Add(30 * time.Second)
+			So(w.Count(), ShouldEqual, 1)
+			So(w.Max(), ShouldEqual, 5)
+
+			now = now.Add(30 * time.Second)
+			So(w.Count(), ShouldEqual, 0)
+
+			// a sub-bucket is reset when it is reused
+			w.Add(7)
+			So(w.Count(), ShouldEqual, 1)
+			So(w.Min(), ShouldEqual, 7)
+		})
+
+		Convey("negative values and custom quantiles should work", func() {
+			w := NewWindow("delta", nil, WindowOptions{
+				Window:     time.Second,
+				SubBuckets: 2,
+				Quantiles:  []float64{0.25, 0.999},
+			})
+			w.now = func() time.Time { return now }
+			for _, v := range []int64{-100, -10, 0, 10, 100} {
+				w.Add(v)
+			}
+			So(w.Quantile(0.25), ShouldEqual, -10)
+			So(w.Quantile(0.5), ShouldEqual, 0)
+			So(w.Quantile(0.75), ShouldEqual, 10)
+
+			values := bucketValues(w.DataPoints())
+			So(values["p25"], ShouldEqual, -10)
+			So(values["p99.9"], ShouldEqual, 100)
+		})
+
+		Convey("windows should be named as buckets are", func() {
+			w := NewWindow("latency", nil, WindowOptions{RollupKey: "stat"})
+			So(w.DataPoints()[0].Dimensions, ShouldResemble, map[string]string{"stat": "count"})
+
+			w = NewWindow("latency", map[string]string{"c": "3"}, WindowOptions{Naming: BucketMetricSuffix})
+			dps := w.DataPoints()
+			So(dps[0].Metric, ShouldEqual, "latency.count")
+			So(dps[0].Dimensions, ShouldResemble, map[string]string{"c": "3"})
+		})
+
+		Convey("reporters should report windows until they are closed", func() {
+			config := NewConfig()
+			config.RoundTripper = okRoundTripper(nil)
+			reporter := NewReporter(config, nil)
+
+			w := reporter.NewWindow("latency", nil)
+			w.Add(3)
+			for i := 0; i < 2; i++ {
+				dps, err := reporter.Report(context.Background())
+				So(err, ShouldBeNil)
+				So(bucketValues(dps)["max"], ShouldEqual, 3)
+			}
+
+			So(len(reporter.Snapshot(false)), ShouldEqual, 6)
+			So(w.Close(), ShouldBeNil)
+			So(len(reporter.Snapshot(false)), ShouldEqual, 0)
+			dps, err := reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(len(dps), ShouldEqual, 0)
+			So(len(reporter.windows), ShouldEqual, 0)
+
+			// closing from a callback must not deadlock
+			w = reporter.NewWindow("latency", nil)
+			w.Add(3)
+			reporter.AddPreReportCallback(func() { w.Close() })
+			dps, err = reporter.Report(context.Background())
+			So(err, ShouldBeNil)
+			So(len(dps), ShouldEqual, 0)
+			So(len(reporter.windows), ShouldEqual, 0)
+
+			w = reporter.NewWindow("latency", nil)
+			reporter.RemoveWindow(w)
+			So(len(reporter.windows), ShouldEqual, 0)
+		})
+	})
+}